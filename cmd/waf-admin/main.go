@@ -8,9 +8,12 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 
 	"github.com/Stack-Dash/waf-admin/internal/api"
+	"github.com/Stack-Dash/waf-admin/internal/auditlog"
+	"github.com/Stack-Dash/waf-admin/internal/geoip"
 	"github.com/Stack-Dash/waf-admin/internal/reload"
 	"github.com/Stack-Dash/waf-admin/internal/render"
 	"github.com/Stack-Dash/waf-admin/internal/scheduler"
@@ -29,16 +32,115 @@ func main() {
 
 	util.SetupLogging()
 
-	stor := storage.NewFS()
+	var stor storage.Storage
+	switch cfg.Storage.Driver {
+	case "fs":
+		stor = storage.NewFS()
+	case "git":
+		g, err := storage.NewGit(storage.GitOptions{
+			Dir:         cfg.Storage.Git.Dir,
+			AuthorName:  cfg.Storage.Git.AuthorName,
+			AuthorEmail: cfg.Storage.Git.AuthorEmail,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("open git storage")
+		}
+		stor = g
+	default:
+		log.Fatal().Str("driver", cfg.Storage.Driver).Msg("unknown storage driver")
+	}
+
+	var driver render.Driver
+	newAdmin := func(addr string) *reload.CaddyAdmin { return reload.NewCaddyAdmin(addr, cfg.Caddy.Caddyfile) }
+	switch cfg.Caddy.Driver {
+	case "caddyfile":
+		driver = render.NewCaddyCoraza(render.CaddyOptions{
+			AdminSocket: cfg.Caddy.AdminSocket,
+			Caddyfile:   cfg.Caddy.Caddyfile,
+			SitesDir:    cfg.Caddy.SitesDir,
+			RulesRoot:   cfg.Caddy.RulesRoot,
+			ScratchDir:  cfg.Caddy.ScratchDir,
+		})
+	case "json":
+		jd := render.NewCaddyJSON(render.JSONOptions{
+			BaseConfig: cfg.Caddy.BaseConfig,
+			Server:     cfg.Caddy.Server,
+			SitesDir:   cfg.Caddy.SitesDir,
+			RulesRoot:  cfg.Caddy.RulesRoot,
+			ScratchDir: cfg.Caddy.ScratchDir,
+		})
+		driver = jd
+		newAdmin = func(addr string) *reload.CaddyAdmin {
+			return reload.NewCaddyAdminConfig(addr, "application/json", jd.Config)
+		}
+	case "nginx":
+		driver = render.NewNginx(render.NginxOptions{
+			Config:      cfg.Nginx.Config,
+			SitesDir:    cfg.Caddy.SitesDir,
+			RulesRoot:   cfg.Caddy.RulesRoot,
+			TestCommand: cfg.Nginx.TestCommand,
+		})
+	case "exec":
+		ed := render.NewExec(cfg.Exec.Command, cfg.Exec.Timeout, cfg.Caddy.SitesDir, cfg.Caddy.RulesRoot, cfg.Exec.SiteExtension)
+		ed.Sites = reload.Sites
+		driver = ed
+	}
+	if sr, ok := driver.(render.SiteRouter); ok && cfg.Caddy.ReloadMode == "incremental" {
+		newAdmin = func(addr string) *reload.CaddyAdmin { return reload.NewCaddyAdminIncremental(addr, sr) }
+	}
 
-	driver := render.NewCaddyCoraza(render.CaddyOptions{
-		AdminSocket: cfg.Caddy.AdminSocket,
-		Caddyfile:   cfg.Caddy.Caddyfile,
-		SitesDir:    cfg.Caddy.SitesDir,
-		RulesRoot:   cfg.Caddy.RulesRoot,
-	})
+	var rl interface {
+		reload.Reloader
+		Stop(context.Context) error
+	}
+	switch cfg.Caddy.Driver {
+	case "nginx":
+		rl = reload.NewPidFile(cfg.Nginx.PidFile)
+	case "exec":
+		rl = reload.NewExec(driver.(*render.Exec).Command())
+	default:
+		rl = newAdmin(cfg.Caddy.AdminSocket)
+	}
+	var canary *reload.Canary
+	if cfg.Rollout.Strategy == "canary" {
+		canary = &reload.Canary{
+			Instance:             cfg.Rollout.Canary,
+			Soak:                 cfg.Rollout.Soak,
+			Interval:             cfg.Rollout.ProbeInterval,
+			MaxBlockRateIncrease: cfg.Rollout.BlockRate.MaxIncrease,
+			MinEvents:            cfg.Rollout.BlockRate.MinEvents,
+		}
+		for _, p := range cfg.Rollout.Probes {
+			canary.Probes = append(canary.Probes, reload.Probe{URL: p.URL, Status: p.Status})
+		}
+	}
+	if len(cfg.Caddy.Instances) > 0 {
+		var instances []reload.Instance
+		for _, in := range cfg.Caddy.Instances {
+			instances = append(instances, reload.Instance{Name: in.Name, Admin: newAdmin(in.Admin)})
+		}
+		fo, err := reload.NewFanOut(instances, canary)
+		if err != nil {
+			log.Fatal().Err(err).Msg("rollout")
+		}
+		rl = fo
+	} else if canary != nil {
+		log.Warn().Msg("rollout.strategy canary needs caddy.instances, reloading adminSocket only")
+	}
 
-	rl := reload.NewCaddyAdmin(cfg.Caddy.AdminSocket, cfg.Caddy.Caddyfile)
+	srv := api.NewServer(cfg, stor, driver, rl)
+	if ev := srv.Events(); ev != nil && canary != nil && cfg.Rollout.BlockRate.MaxIncrease > 0 {
+		canary.Blocks = func(instances []string, from, to time.Time) (total, blocked int, err error) {
+			for _, in := range instances {
+				t, b, err := ev.Count(auditlog.Filter{Instance: in, From: from, To: to})
+				if err != nil {
+					return 0, 0, err
+				}
+				total, blocked = total+t, blocked+b
+			}
+			return total, blocked, nil
+		}
+	}
 
 	sched := scheduler.New()
 	if cfg.Backup.Enabled {
@@ -55,10 +157,37 @@ func main() {
 			log.Fatal().Err(err).Msg("schedule geoip update")
 		}
 	}
+	if err := sched.AddEvery("prune-expired", time.Minute, func(ctx context.Context) error {
+		if err := srv.PruneExpired(ctx); err != nil {
+			log.Error().Err(err).Msg("prune expired entries")
+			return err
+		}
+		return nil
+	}); err != nil {
+		log.Fatal().Err(err).Msg("schedule expiry")
+	}
+	runCtx, stopRun := context.WithCancel(context.Background())
+	defer stopRun()
+	if ev := srv.Events(); ev != nil {
+		var sources []auditlog.Source
+		for _, l := range cfg.Audit.Logs {
+			sources = append(sources, auditlog.Source{
+				Path:   l.Path,
+				Parser: auditlog.Parser{Site: l.Site, Instance: l.Instance, RulesRoot: cfg.Caddy.RulesRoot, SiteForHost: srv.SiteForHost},
+			})
+		}
+		in := auditlog.NewIngester(ev, sources)
+		in.OnEvent = auditlog.NewMetrics(prometheus.DefaultRegisterer, geoip.Open(cfg.GeoIP.DatabasePath()).Country, srv.KnownSite).Observe
+		go in.Run(runCtx)
+		if err := sched.AddEvery("audit-retention", time.Hour, func(ctx context.Context) error {
+			return ev.Prune()
+		}); err != nil {
+			log.Fatal().Err(err).Msg("schedule audit retention")
+		}
+	}
 	sched.Start()
 	defer sched.Stop()
 
-	srv := api.NewServer(cfg, stor, driver, rl)
 	go func() {
 		if err := srv.Start(); err != nil {
 			log.Fatal().Err(err).Msg("http server")
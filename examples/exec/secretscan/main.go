@@ -0,0 +1,96 @@
+// Command secretscan is an example executable for the exec driver. It
+// rejects rule and site files containing private keys or AWS access key
+// IDs, and reloads by running the command given after "--", if any:
+//
+//	secretscan -- caddy reload --config /etc/caddy/Caddyfile
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Stack-Dash/waf-admin/internal/execproto"
+)
+
+var secrets = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----|\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)
+
+func main() {
+	var req execproto.Request
+	resp := execproto.Response{OK: true}
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		resp = fail(execproto.Issue{Message: "bad request: " + err.Error()})
+	} else {
+		switch req.Operation {
+		case execproto.Validate:
+			resp = validate(req)
+		case execproto.Reload:
+			resp = reload()
+		case execproto.Stop, execproto.Hosts:
+		default:
+			resp = fail(execproto.Issue{Message: "unknown operation " + req.Operation})
+		}
+	}
+	_ = json.NewEncoder(os.Stdout).Encode(resp)
+}
+
+func fail(issues ...execproto.Issue) execproto.Response {
+	return execproto.Response{Errors: issues}
+}
+
+// validate scans the changed paths, or the whole layout when the request
+// names none.
+func validate(req execproto.Request) execproto.Response {
+	paths := req.Paths
+	if len(paths) == 0 {
+		for _, dir := range []string{req.Layout.SitesDir, req.Layout.RulesRoot} {
+			_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					paths = append(paths, p)
+				}
+				return nil
+			})
+		}
+	}
+	var issues []execproto.Issue
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			continue // deleted
+		}
+		sc := bufio.NewScanner(bytes.NewReader(b))
+		for n := 1; sc.Scan(); n++ {
+			if secrets.MatchString(sc.Text()) {
+				issues = append(issues, execproto.Issue{File: p, Line: n, Message: "looks like a secret"})
+			}
+		}
+	}
+	if len(issues) > 0 {
+		return fail(issues...)
+	}
+	return execproto.Response{OK: true}
+}
+
+func reload() execproto.Response {
+	i := slices.Index(os.Args, "--")
+	if i < 0 || i == len(os.Args)-1 {
+		return execproto.Response{OK: true}
+	}
+	args := os.Args[i+1:]
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fail(execproto.Issue{Message: msg})
+	}
+	return execproto.Response{OK: true}
+}
@@ -0,0 +1,66 @@
+#!/bin/sh
+# Stand-in for nginx to try the nginx driver without nginx installed.
+#
+#   fake-nginx.sh -t [-q] -c nginx.conf   check the configuration
+#   fake-nginx.sh -p nginx.pid            run, logging SIGHUP reloads
+#
+# The check follows include and modsecurity_rules_file directives and the
+# ModSecurity Include directives in rule files, and fails on missing
+# files, unbalanced braces in nginx files and any file containing BROKEN.
+
+check() {
+  file=$1
+  nginx=$2
+  if [ ! -f "$file" ]; then
+    echo "nginx: [emerg] open() \"$file\" failed (2: No such file or directory)" >&2
+    exit 1
+  fi
+  if grep -q BROKEN "$file"; then
+    echo "nginx: [emerg] invalid directive in $file" >&2
+    exit 1
+  fi
+  if [ "$nginx" = 1 ]; then
+    depth=$(sed 's/#.*//' "$file" | tr -cd '{}' | awk '{ n = gsub(/{/, ""); m = gsub(/}/, ""); print n - m }')
+    if [ "${depth:-0}" != 0 ]; then
+      echo "nginx: [emerg] unexpected end of file, expecting \"}\" in $file" >&2
+      exit 1
+    fi
+    for inc in $(sed -n 's/^[[:space:]]*include[[:space:]]\{1,\}\([^;]*\);.*/\1/p' "$file"); do
+      for f in $inc; do
+        [ -e "$f" ] && check "$f" 1
+      done
+    done
+    for rf in $(sed -n 's/^[[:space:]]*modsecurity_rules_file[[:space:]]\{1,\}\([^;]*\);.*/\1/p' "$file"); do
+      check "$rf" 0
+    done
+  else
+    for inc in $(sed -n 's/^[[:space:]]*Include[[:space:]]\{1,\}"\{0,1\}\([^"]*\)"\{0,1\}.*/\1/p' "$file"); do
+      for f in $inc; do
+        [ -e "$f" ] && check "$f" 0
+      done
+    done
+  fi
+}
+
+case "$1" in
+-t)
+  shift
+  [ "$1" = "-q" ] && shift
+  [ "$1" = "-c" ] || { echo "usage: $0 -t [-q] -c nginx.conf" >&2; exit 2; }
+  check "$2" 1
+  ;;
+-p)
+  echo $$ > "$2"
+  trap 'echo "$(date +%T) reload"' HUP
+  trap 'echo "$(date +%T) quit"; rm -f "$2"; exit 0' QUIT
+  echo "$(date +%T) running as $$"
+  while :; do
+    sleep 1 &
+    wait $!
+  done
+  ;;
+*)
+  echo "usage: $0 -t [-q] -c nginx.conf | -p nginx.pid" >&2
+  exit 2
+  ;;
+esac
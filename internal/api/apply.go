@@ -0,0 +1,431 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Stack-Dash/waf-admin/internal/crs"
+	"github.com/Stack-Dash/waf-admin/internal/domain"
+	"github.com/Stack-Dash/waf-admin/internal/hooks"
+	"github.com/Stack-Dash/waf-admin/internal/reload"
+	"github.com/Stack-Dash/waf-admin/internal/revision"
+	"github.com/Stack-Dash/waf-admin/internal/storage"
+)
+
+var (
+	errNotFound           = errors.New("not found")
+	errExists             = errors.New("target already exists")
+	errPreconditionFailed = errors.New("precondition failed")
+	errManaged            = errors.New("file is managed by waf-admin")
+)
+
+// fileChange is a pending write of Data to Path, or its removal when Delete
+// is set. Key identifies the file in the revision history. A non-empty
+// IfMatch must match the ETag of the current content (see ifMatch).
+//
+// When RenameFrom is set the file at RenameFrom (history key RenameKey) is
+// moved to Path instead; Data is filled in with its content and IfMatch
+// applies to it.
+//
+// Managed rule files (see managedRuleFile) can only be changed with
+// Managed set.
+type fileChange struct {
+	Key     string
+	Path    string
+	Data    []byte
+	Delete  bool
+	IfMatch string
+	Managed bool
+
+	RenameFrom string
+	RenameKey  string
+}
+
+// applyError wraps a validate/reload failure after which the previous
+// content has been restored.
+type applyError struct{ err error }
+
+func (e *applyError) Error() string { return "validate/apply failed: " + e.err.Error() }
+func (e *applyError) Unwrap() error { return e.err }
+
+// writeApplyErr maps err to a status. A failed reload on caddy.instances
+// is answered with JSON carrying the outcome on each instance.
+func writeApplyErr(w http.ResponseWriter, err error) {
+	var ae *applyError
+	switch {
+	case errors.Is(err, errNotFound):
+		writeErr(w, 404, "not found")
+	case errors.Is(err, errExists), errors.Is(err, errManaged):
+		writeErr(w, 409, err.Error())
+	case errors.Is(err, errPreconditionFailed):
+		writeErr(w, 412, "precondition failed: file was changed concurrently")
+	case errors.As(err, new(*domain.ConflictError)):
+		writeErr(w, 409, err.Error())
+	case errors.As(err, &ae):
+		var fe *reload.FanOutError
+		if errors.As(err, &fe) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(400)
+			_ = json.NewEncoder(w).Encode(map[string]any{"error": ae.Error(), "instances": fe.Results})
+			return
+		}
+		writeErr(w, 400, ae.Error())
+	default:
+		writeErr(w, 500, err.Error())
+	}
+}
+
+type applyResultsKey struct{}
+
+// applyResults is where applyNow leaves what happened after the write of a
+// request, for its response: the post-apply hook results and, with
+// caddy.instances, the outcome on each instance.
+type applyResults struct {
+	hooks     []hooks.Result
+	instances []reload.Result
+}
+
+// collectResults gives every request an applyResults, see withResults.
+func collectResults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), applyResultsKey{}, new(applyResults))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func resultsOf(ctx context.Context) *applyResults {
+	if p, ok := ctx.Value(applyResultsKey{}).(*applyResults); ok {
+		return p
+	}
+	return new(applyResults)
+}
+
+// recordInstances keeps the outcome of the reload that just ran on each
+// Caddy instance for the response. The caller holds applyMu, so it is the
+// request's own reload.
+func (s *Server) recordInstances(ctx context.Context) {
+	if fo, ok := s.rel.(*reload.FanOut); ok {
+		resultsOf(ctx).instances = fo.Results()
+	}
+}
+
+// withResults adds the post-apply hook results and the instance outcomes
+// of the request to resp.
+func withResults(r *http.Request, resp map[string]any) map[string]any {
+	ar := resultsOf(r.Context())
+	if len(ar.hooks) > 0 {
+		resp["hooks"] = ar.hooks
+	}
+	if len(ar.instances) > 0 {
+		resp["instances"] = ar.instances
+	}
+	return resp
+}
+
+// applyChange writes ch, validates and reloads, restoring the previous
+// content if that fails. On success the new state is recorded as a
+// revision of ch.Key carrying the author and op from meta.
+func (s *Server) applyChange(ctx context.Context, ch fileChange, meta revision.Revision) (revision.Revision, error) {
+	revs, err := s.applyChanges(ctx, []fileChange{ch}, meta)
+	if err != nil {
+		return revision.Revision{}, err
+	}
+	return revs[0], nil
+}
+
+// applyChanges writes all changes, then validates and reloads once. If any
+// write or the apply fails, every file already touched is restored to its
+// previous content. On success one revision per change is recorded; an
+// empty meta.Op is recorded as "put" or "delete" per change.
+//
+// The whole sequence runs under applyMu so that concurrent mutations
+// cannot interleave their writes and restores. The post-apply hooks run
+// after it is released, so slow hooks do not hold up other writes.
+func (s *Server) applyChanges(ctx context.Context, changes []fileChange, meta revision.Revision) ([]revision.Revision, error) {
+	revs, err := s.applyLocked(ctx, changes, meta)
+	if err != nil {
+		return nil, err
+	}
+	s.runPostHooks(withChangedSites(ctx, changes))
+	return revs, nil
+}
+
+func (s *Server) applyLocked(ctx context.Context, changes []fileChange, meta revision.Revision) ([]revision.Revision, error) {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	changes = append([]fileChange(nil), changes...)
+	origs := make([][]byte, len(changes))
+	hadOrig := make([]bool, len(changes))
+	for i, ch := range changes {
+		if !ch.Managed && (isManagedKey(ch.Key) || isManagedKey(ch.RenameKey)) {
+			return nil, errManaged
+		}
+		if ch.RenameFrom != "" {
+			if _, err := s.store.Read(ctx, ch.Path); err == nil {
+				return nil, errExists
+			}
+			b, err := s.store.Read(ctx, ch.RenameFrom)
+			if err != nil {
+				return nil, errNotFound
+			}
+			origs[i], hadOrig[i] = b, true
+			changes[i].Data = b
+		} else {
+			b, err := s.store.Read(ctx, ch.Path)
+			origs[i], hadOrig[i] = b, err == nil
+		}
+		if ch.IfMatch != "" && !ifMatch(ch.IfMatch, origs[i], hadOrig[i]) {
+			return nil, errPreconditionFailed
+		}
+		if ch.Delete && !hadOrig[i] {
+			return nil, errNotFound
+		}
+	}
+	if err := s.checkRuleIDs(ctx, changes); err != nil {
+		return nil, err
+	}
+
+	restore := func(n int) {
+		for i := n - 1; i >= 0; i-- {
+			ch := changes[i]
+			restoreCtx := storage.WithMessage(context.Background(), "restore "+ch.Key+" after failed apply")
+			if ch.RenameFrom != "" {
+				if err := s.store.Rename(restoreCtx, ch.Path, ch.RenameFrom); err != nil {
+					log.Error().Err(err).Str("path", ch.Path).Msg("rename back failed")
+				}
+			} else if hadOrig[i] {
+				if err := s.store.WriteAtomic(restoreCtx, ch.Path, origs[i], 0o644); err != nil {
+					log.Error().Err(err).Str("path", ch.Path).Msg("restore failed")
+				}
+			} else if !ch.Delete {
+				if err := s.store.Delete(restoreCtx, ch.Path); err != nil {
+					log.Error().Err(err).Str("path", ch.Path).Msg("delete new file failed")
+				}
+			}
+		}
+	}
+
+	for i, ch := range changes {
+		wctx := storage.WithMessage(ctx, changeMessage(ch, meta))
+		var err error
+		switch {
+		case ch.RenameFrom != "":
+			err = s.store.Rename(wctx, ch.RenameFrom, ch.Path)
+		case ch.Delete:
+			err = s.store.Delete(wctx, ch.Path)
+		default:
+			err = s.store.WriteAtomic(wctx, ch.Path, ch.Data, 0o644)
+		}
+		if err != nil {
+			// a change that was made but not committed is undone too
+			if errors.As(err, new(*storage.CommitError)) {
+				i++
+			}
+			restore(i)
+			return nil, err
+		}
+	}
+
+	if err := s.applyNow(withChangedSites(ctx, changes)); err != nil {
+		restore(len(changes))
+		return nil, &applyError{err}
+	}
+
+	revs := make([]revision.Revision, len(changes))
+	for i, ch := range changes {
+		if ch.RenameFrom != "" {
+			s.recordRevision(fileChange{Key: ch.RenameKey, Path: ch.RenameFrom, Delete: true}, origs[i], true, meta)
+			revs[i] = s.recordRevision(ch, nil, false, meta)
+			continue
+		}
+		revs[i] = s.recordRevision(ch, origs[i], hadOrig[i], meta)
+	}
+	return revs, nil
+}
+
+// checkRuleIDs rejects changes that would define a rule ID twice within a
+// site's effective ruleset: its enabled rule files plus the CRS release it
+// pins. Only conflicts involving a changed file are reported, so
+// pre-existing duplicates elsewhere do not block unrelated writes. A
+// changed CRS pin counts as a change to every file of the new release.
+func (s *Server) checkRuleIDs(ctx context.Context, changes []fileChange) error {
+	type target struct{ site, file string }
+	var touched []target
+	pins := map[string]string{}
+	for _, ch := range changes {
+		if site, file, ok := ruleTarget(ch.Key); ok {
+			touched = append(touched, target{site, file})
+		}
+		if site, file, ok := crsTarget(ch.Key); ok && file == crs.ConfFile {
+			pins[site] = ""
+			if !ch.Delete {
+				pins[site] = crs.PinnedVersion(ch.Data)
+			}
+		}
+	}
+	if len(touched) == 0 && len(pins) == 0 {
+		return nil
+	}
+	ix, crsFiles, err := s.ruleIndex(ctx, pins)
+	if err != nil {
+		return err
+	}
+	for site := range pins {
+		for _, f := range crsFiles[site] {
+			touched = append(touched, target{site, f})
+		}
+	}
+	for _, ch := range changes {
+		if site, file, ok := ruleTarget(ch.RenameKey); ok {
+			ix.Remove(site, file)
+		}
+		site, file, ok := ruleTarget(ch.Key)
+		switch {
+		case !ok:
+		case ch.Delete:
+			ix.Remove(site, file)
+		default:
+			ix.Set(site, file, ch.Data)
+		}
+	}
+	for _, t := range touched {
+		if c := ix.Conflicts(t.site, t.file); len(c) > 0 {
+			return &domain.ConflictError{Site: t.site, Conflicts: c}
+		}
+	}
+	return nil
+}
+
+// recordRevision stores the applied state of ch. Files that existed before
+// waf-admin first touched them get their original content recorded as an
+// "import" revision so they can be rolled back to. Failures are logged
+// only, since the change itself is already live.
+func (s *Server) recordRevision(ch fileChange, orig []byte, hadOrig bool, meta revision.Revision) revision.Revision {
+	if hadOrig {
+		if empty, err := s.revs.Empty(ch.Key); err == nil && empty {
+			if _, err := s.revs.Record(ch.Key, revision.Revision{Author: "import", Op: "import", Content: string(orig)}); err != nil {
+				log.Error().Err(err).Str("key", ch.Key).Msg("record import revision failed")
+			}
+		}
+	}
+	meta.Op = changeOp(ch, meta)
+	meta.Deleted = ch.Delete
+	meta.Content = string(ch.Data)
+	rev, err := s.revs.Record(ch.Key, meta)
+	if err != nil {
+		log.Error().Err(err).Str("key", ch.Key).Msg("record revision failed")
+	}
+	return rev
+}
+
+// changeMessage describes a change for storage backends that keep their
+// own history, e.g. "put rules/example/10-custom.conf by alice".
+func changeMessage(ch fileChange, meta revision.Revision) string {
+	msg := changeOp(ch, meta) + " " + ch.Key
+	if ch.RenameKey != "" {
+		msg = changeOp(ch, meta) + " " + ch.RenameKey + " as " + ch.Key
+	}
+	if meta.RollbackOf > 0 {
+		msg += fmt.Sprintf(" to revision %d", meta.RollbackOf)
+	}
+	if meta.Changeset != "" {
+		msg += " in changeset " + meta.Changeset
+	}
+	return msg + " by " + meta.Author
+}
+
+func changeOp(ch fileChange, meta revision.Revision) string {
+	switch {
+	case meta.Op != "":
+		return meta.Op
+	case ch.Delete:
+		return "delete"
+	default:
+		return "put"
+	}
+}
+
+func etag(b []byte) string { return `"` + revision.Hash(b) + `"` }
+
+// ifMatch evaluates an If-Match header value against the current content:
+// "*" matches any existing file, otherwise one of the listed ETags must
+// equal the ETag of cur.
+func ifMatch(header string, cur []byte, exists bool) bool {
+	if !exists {
+		return false
+	}
+	want := etag(cur)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == want {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) sitePath(site string) string {
+	return filepath.Join(s.driver.LayoutSites(), site+s.driver.SiteExtension())
+}
+
+func (s *Server) rulePath(site, file string) string {
+	return filepath.Join(s.driver.LayoutRulesRoot(), site, "rules", file)
+}
+
+func siteKey(site string) string       { return "sites/" + site }
+func ruleKey(site, file string) string { return "rules/" + site + "/" + file }
+
+// ruleTarget splits a rule history key back into site and file.
+func ruleTarget(key string) (site, file string, ok bool) {
+	rest, ok := strings.CutPrefix(key, "rules/")
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, "/")
+}
+
+func isManagedKey(key string) bool {
+	_, file, ok := ruleTarget(key)
+	return ok && managedRuleFile(file)
+}
+
+// crsTarget splits a CRS history key back into site and file.
+func crsTarget(key string) (site, file string, ok bool) {
+	rest, ok := strings.CutPrefix(key, "crs/")
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, "/")
+}
+
+// ruleSites returns the sites that have a directory under RulesRoot.
+func (s *Server) ruleSites(ctx context.Context) []string {
+	ents, err := s.store.List(ctx, s.driver.LayoutRulesRoot())
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range ents {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			out = append(out, e.Name())
+		}
+	}
+	return out
+}
+
+// author identifies who made a change. The bearer token is shared, so
+// clients are expected to name themselves via X-Author.
+func author(r *http.Request) string {
+	if a := r.Header.Get("X-Author"); a != "" {
+		return a
+	}
+	return "api"
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Stack-Dash/waf-admin/internal/changeset"
+	"github.com/Stack-Dash/waf-admin/internal/diff"
+	"github.com/Stack-Dash/waf-admin/internal/hooks"
+	"github.com/Stack-Dash/waf-admin/internal/reload"
+	"github.com/Stack-Dash/waf-admin/internal/revision"
+)
+
+type createChangesetReq struct {
+	Description string             `json:"description"`
+	Changes     []changeset.Change `json:"changes"`
+}
+
+// stagedChange validates a staged change and resolves it to the file it
+// writes.
+func (s *Server) stagedChange(c changeset.Change) (fileChange, error) {
+	if !siteNameRe.MatchString(c.Site) {
+		return fileChange{}, errors.New("invalid site name")
+	}
+	var ch fileChange
+	switch c.Kind {
+	case "site":
+		if c.File != "" {
+			return fileChange{}, errors.New("file must be empty for site changes")
+		}
+		ch = fileChange{Key: siteKey(c.Site), Path: s.sitePath(c.Site)}
+	case "rule":
+		if !fileNameRe.MatchString(c.File) {
+			return fileChange{}, errors.New("invalid file name")
+		}
+		if managedRuleFile(c.File) {
+			return fileChange{}, errManaged
+		}
+		ch = fileChange{Key: ruleKey(c.Site, c.File), Path: s.rulePath(c.Site, c.File)}
+	default:
+		return fileChange{}, errors.New("kind must be site or rule")
+	}
+	ch.IfMatch = c.IfMatch
+	switch c.Op {
+	case "put":
+		if strings.TrimSpace(c.Content) == "" {
+			return fileChange{}, errors.New("invalid content")
+		}
+		ch.Data = []byte(c.Content)
+	case "delete":
+		ch.Delete = true
+	default:
+		return fileChange{}, errors.New("op must be put or delete")
+	}
+	return ch, nil
+}
+
+// stagedLintRejected lints the content of a staged rule put like putRule
+// does and, if it has errors, writes the same 422 response.
+func stagedLintRejected(w http.ResponseWriter, c changeset.Change) bool {
+	return c.Kind == "rule" && c.Op == "put" && lintRejected(w, c.Content)
+}
+
+func writeChangesetErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, changeset.ErrNotFound):
+		writeErr(w, 404, err.Error())
+	case errors.Is(err, changeset.ErrNotOpen):
+		writeErr(w, 409, err.Error())
+	default:
+		writeErr(w, 500, err.Error())
+	}
+}
+
+func (s *Server) listChangesets(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.changesets.List(), nil)
+}
+
+func (s *Server) createChangeset(w http.ResponseWriter, r *http.Request) {
+	var req createChangesetReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, 400, "invalid body")
+		return
+	}
+	for _, c := range req.Changes {
+		if _, err := s.stagedChange(c); err != nil {
+			writeErr(w, 400, err.Error())
+			return
+		}
+		if stagedLintRejected(w, c) {
+			return
+		}
+	}
+	cs := s.changesets.Create(req.Description, author(r))
+	for _, c := range req.Changes {
+		var err error
+		if cs, err = s.changesets.Stage(cs.ID, c); err != nil {
+			writeChangesetErr(w, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(cs)
+}
+
+func (s *Server) getChangeset(w http.ResponseWriter, r *http.Request) {
+	cs, err := s.changesets.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		writeChangesetErr(w, err)
+		return
+	}
+	writeJSON(w, cs, nil)
+}
+
+func (s *Server) deleteChangeset(w http.ResponseWriter, r *http.Request) {
+	if err := s.changesets.Delete(chi.URLParam(r, "id")); err != nil {
+		writeChangesetErr(w, err)
+		return
+	}
+	writeJSON(w, map[string]any{"ok": true}, nil)
+}
+
+func (s *Server) stageChange(w http.ResponseWriter, r *http.Request) {
+	var c changeset.Change
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeErr(w, 400, "invalid body")
+		return
+	}
+	if _, err := s.stagedChange(c); err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	if stagedLintRejected(w, c) {
+		return
+	}
+	cs, err := s.changesets.Stage(chi.URLParam(r, "id"), c)
+	if err != nil {
+		writeChangesetErr(w, err)
+		return
+	}
+	writeJSON(w, cs, nil)
+}
+
+func (s *Server) unstageChange(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil {
+		writeErr(w, 400, "invalid index")
+		return
+	}
+	cs, err := s.changesets.Unstage(chi.URLParam(r, "id"), i)
+	if err != nil {
+		writeChangesetErr(w, err)
+		return
+	}
+	writeJSON(w, cs, nil)
+}
+
+type changePreview struct {
+	changeset.Change
+	Path        string `json:"path"`
+	Exists      bool   `json:"exists"`
+	CurrentHash string `json:"currentHash,omitempty"`
+	NewHash     string `json:"newHash,omitempty"`
+	Changed     bool   `json:"changed"`
+	Diff        string `json:"diff,omitempty"`
+}
+
+// previewChangeset compares every staged change against what is on disk
+// right now, without writing anything.
+func (s *Server) previewChangeset(w http.ResponseWriter, r *http.Request) {
+	cs, err := s.changesets.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		writeChangesetErr(w, err)
+		return
+	}
+	out := make([]changePreview, 0, len(cs.Changes))
+	for _, c := range cs.Changes {
+		ch, err := s.stagedChange(c)
+		if err != nil {
+			writeErr(w, 400, err.Error())
+			return
+		}
+		p := changePreview{Change: c, Path: ch.Path}
+		cur, err := s.store.Read(r.Context(), ch.Path)
+		if err == nil {
+			p.Exists = true
+			p.CurrentHash = revision.Hash(cur)
+		}
+		if !ch.Delete {
+			p.NewHash = revision.Hash(ch.Data)
+		}
+		p.Changed = p.CurrentHash != p.NewHash
+		p.Diff = diff.Unified("a"+ch.Path, "b"+ch.Path, string(cur), string(ch.Data))
+		out = append(out, p)
+	}
+	writeJSON(w, out, nil)
+}
+
+// commitChangeset writes every staged file, validates and reloads once and
+// restores all of them if anything fails.
+func (s *Server) commitChangeset(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	cs, err := s.changesets.Begin(id)
+	if err != nil {
+		writeChangesetErr(w, err)
+		return
+	}
+	if len(cs.Changes) == 0 {
+		s.changesets.Finish(id, nil, errors.New("changeset is empty"))
+		writeErr(w, 400, "changeset is empty")
+		return
+	}
+	changes := make([]fileChange, 0, len(cs.Changes))
+	for _, c := range cs.Changes {
+		ch, err := s.stagedChange(c)
+		if err != nil {
+			s.changesets.Finish(id, nil, err)
+			writeErr(w, 400, err.Error())
+			return
+		}
+		if stagedLintRejected(w, c) {
+			s.changesets.Finish(id, nil, fmt.Errorf("rules/%s/%s has lint errors", c.Site, c.File))
+			return
+		}
+		if c.Kind == "site" && !ch.Delete {
+			if err := s.store.MkdirAll(r.Context(), filepath.Join(s.driver.LayoutRulesRoot(), c.Site, "rules"), 0o755); err != nil {
+				s.changesets.Finish(id, nil, err)
+				writeErr(w, 500, err.Error())
+				return
+			}
+		}
+		changes = append(changes, ch)
+	}
+	s.managedMu.Lock()
+	defer s.managedMu.Unlock()
+	installed := map[string]bool{}
+	for _, c := range cs.Changes {
+		if c.Kind != "site" || c.Op != "put" || installed[c.Site] {
+			continue
+		}
+		installed[c.Site] = true
+		ipl, err := s.ipListInstall(r.Context(), c.Site)
+		if err != nil {
+			s.changesets.Finish(id, nil, err)
+			writeErr(w, 500, err.Error())
+			return
+		}
+		changes = append(changes, ipl...)
+	}
+
+	revs, err := s.applyChanges(r.Context(), changes, revision.Revision{Author: author(r), Changeset: id})
+	if err != nil {
+		s.changesets.Finish(id, nil, err)
+		writeApplyErr(w, err)
+		return
+	}
+	// revisions of the staged changes only, not of installed IP list files
+	nums := make([]int, len(cs.Changes))
+	for i, rev := range revs[:len(cs.Changes)] {
+		nums[i] = rev.Number
+	}
+	done := s.changesets.Finish(id, nums, nil)
+	ar := resultsOf(r.Context())
+	writeJSON(w, struct {
+		*changeset.Changeset
+		Hooks     []hooks.Result  `json:"hooks,omitempty"`
+		Instances []reload.Result `json:"instances,omitempty"`
+	}{done, ar.hooks, ar.instances}, nil)
+}
@@ -1,7 +1,11 @@
 package api
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -15,17 +19,113 @@ type Config struct {
 		Token string `yaml:"token"`
 	} `yaml:"auth"`
 
-	Caddy struct {
-		AdminSocket string `yaml:"adminSocket"`
-		Caddyfile   string `yaml:"caddyfile"`
-		SitesDir    string `yaml:"sitesDir"`
-		RulesRoot   string `yaml:"rulesRoot"`
-	} `yaml:"caddy"`
+	Caddy CaddyConfig `yaml:"caddy"`
 
+	// Nginx configures the nginx driver (caddy.driver: nginx), which
+	// keeps using caddy.sitesDir and caddy.rulesRoot for the layout.
+	Nginx struct {
+		Config      string   `yaml:"config"`
+		PidFile     string   `yaml:"pidFile"`
+		TestCommand []string `yaml:"testCommand"`
+	} `yaml:"nginx"`
+
+	// Exec configures the exec driver (caddy.driver: exec): Command is run
+	// with an execproto request for every validation and reload.
+	Exec struct {
+		Command       []string      `yaml:"command"`
+		Timeout       time.Duration `yaml:"timeout"`
+		SiteExtension string        `yaml:"siteExtension"`
+	} `yaml:"exec"`
+
+	Storage struct {
+		Driver string `yaml:"driver"`
+		Git    struct {
+			Dir         string `yaml:"dir"`
+			AuthorName  string `yaml:"authorName"`
+			AuthorEmail string `yaml:"authorEmail"`
+		} `yaml:"git"`
+	} `yaml:"storage"`
+
+	History struct {
+		Dir string `yaml:"dir"`
+	} `yaml:"history"`
+
+	CRS struct {
+		Dir string `yaml:"dir"`
+	} `yaml:"crs"`
+
+	Rollout RolloutConfig `yaml:"rollout"`
+
+	// Tests.Enforce runs the regression tests of the changed sites before
+	// every reload and rejects changes that make a test fail.
+	Tests struct {
+		Enforce bool `yaml:"enforce"`
+	} `yaml:"tests"`
+
+	// Hooks run around every apply, see HookConfig.
+	Hooks struct {
+		Pre  []HookConfig `yaml:"pre"`
+		Post []HookConfig `yaml:"post"`
+	} `yaml:"hooks"`
+
+	Audit  AuditConfig  `yaml:"audit"`
 	Backup BackupConfig `yaml:"backup"`
 	GeoIP  GeoIPConfig  `yaml:"geoip"`
 }
 
+// AuditConfig lists the Coraza JSON audit logs to ingest. Site pins all
+// events of a log to one site; otherwise the site is derived from the
+// matched rule files or the Host header. Instance names the Caddy instance
+// (see caddy.instances) writing the log, for canary rollouts.
+type AuditConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Dir       string        `yaml:"dir"`
+	Retention time.Duration `yaml:"retention"`
+	Logs      []struct {
+		Path     string `yaml:"path"`
+		Site     string `yaml:"site"`
+		Instance string `yaml:"instance"`
+	} `yaml:"logs"`
+}
+
+// RolloutConfig controls how changes reach caddy.instances. Strategy "all"
+// (the default) reloads them one after the other; "canary" reloads Canary
+// (default the first instance) first and watches it for Soak: Probes must
+// keep answering with their status, and with audit ingestion and
+// BlockRate.MaxIncrease set, its block rate must not exceed that of the
+// other instances by more than MaxIncrease. The soak runs inside the
+// write request while it holds the apply lock, so it is capped at maxSoak.
+type RolloutConfig struct {
+	Strategy      string        `yaml:"strategy"`
+	Canary        string        `yaml:"canary"`
+	Soak          time.Duration `yaml:"soak"`
+	ProbeInterval time.Duration `yaml:"probeInterval"`
+	Probes        []struct {
+		URL    string `yaml:"url"`
+		Status int    `yaml:"status"`
+	} `yaml:"probes"`
+	BlockRate struct {
+		MaxIncrease float64 `yaml:"maxIncrease"`
+		MinEvents   int     `yaml:"minEvents"`
+	} `yaml:"blockRate"`
+}
+
+// maxSoak keeps a canary apply, which holds the request open and blocks
+// other writes, well below the usual 60s proxy and client timeouts.
+const maxSoak = 30 * time.Second
+
+// HookConfig is a command, run with the apply described as JSON on
+// stdin, or a URL the description is POSTed to. Pre-apply hooks run
+// before validation and a failing one rejects the change; post-apply
+// hooks run after the reload and only report.
+type HookConfig struct {
+	Name    string            `yaml:"name"`
+	Command []string          `yaml:"command"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout time.Duration     `yaml:"timeout"`
+}
+
 type BackupConfig struct {
 	Enabled bool   `yaml:"enabled"`
 	Daily   string `yaml:"daily"`
@@ -46,11 +146,66 @@ type GeoIPConfig struct {
 	DatabaseDir string `yaml:"databaseDir"`
 }
 
+// DatabasePath is the country database inside DatabaseDir, shared by the
+// daily update and waf-admin's own lookups.
+func (c GeoIPConfig) DatabasePath() string {
+	return filepath.Join(c.DatabaseDir, "GeoLite2-Country.mmdb")
+}
+
+// checkGitDir requires the git working tree to contain the sites and the
+// rules, since git storage rejects writes elsewhere.
+func checkGitDir(dir string, inside ...string) error {
+	if dir == "" {
+		return fmt.Errorf("storage.git.dir is required for the git driver")
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if root == "/" {
+		return fmt.Errorf("storage.git.dir must not be /")
+	}
+	for _, p := range inside {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(root, abs); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("storage.git.dir %s must contain %s", dir, p)
+		}
+	}
+	return nil
+}
+
 type CaddyConfig struct {
+	// Driver is "caddyfile" (the default), where sites are Caddyfile
+	// snippets imported by Caddyfile, "json", where sites are route
+	// fragments that waf-admin merges into BaseConfig under Server and
+	// loads as JSON, "nginx" for nginx with ModSecurity v3 (see Nginx), or
+	// "exec" to delegate to an external executable (see Exec).
+	Driver      string `yaml:"driver"`
 	AdminSocket string `yaml:"adminSocket"`
 	Caddyfile   string `yaml:"caddyfile"`
+	BaseConfig  string `yaml:"baseConfig"`
+	Server      string `yaml:"server"`
 	SitesDir    string `yaml:"sitesDir"`
 	RulesRoot   string `yaml:"rulesRoot"`
+	ScratchDir  string `yaml:"scratchDir"`
+	// ReloadMode is "full" (the default), loading the whole config on
+	// every change, or "incremental", replacing only the routes of the
+	// changed sites by their @id.
+	ReloadMode string `yaml:"reloadMode"`
+	// Instances are reloaded together after every change. The caddyfile
+	// driver validates through AdminSocket; without instances it is also
+	// the only instance reloaded.
+	Instances []CaddyInstance `yaml:"instances"`
+}
+
+// CaddyInstance is one Caddy sharing the sites and rules. Admin is a unix
+// socket path or a TCP admin address such as "10.0.0.2:2019".
+type CaddyInstance struct {
+	Name  string `yaml:"name"`
+	Admin string `yaml:"admin"`
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -65,9 +220,101 @@ func LoadConfig(path string) (*Config, error) {
 	if cfg.Server.Bind == "" {
 		cfg.Server.Bind = ":8080"
 	}
+	if cfg.Storage.Driver == "" {
+		cfg.Storage.Driver = "fs"
+	}
+	if cfg.Storage.Driver == "git" {
+		if err := checkGitDir(cfg.Storage.Git.Dir, cfg.Caddy.SitesDir, cfg.Caddy.RulesRoot); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.History.Dir == "" {
+		cfg.History.Dir = "/var/lib/waf-admin/history"
+	}
+	if cfg.CRS.Dir == "" {
+		cfg.CRS.Dir = "/etc/coraza/crs"
+	}
+	if cfg.Audit.Dir == "" {
+		cfg.Audit.Dir = "/var/lib/waf-admin/events"
+	}
+	if cfg.Audit.Retention == 0 {
+		cfg.Audit.Retention = 14 * 24 * time.Hour
+	}
 	if cfg.GeoIP.DatabaseURL == "" {
 		cfg.GeoIP.DatabaseURL = "https://github.com/P3TERX/GeoLite.mmdb/releases/latest/download/GeoLite2-Country.mmdb"
 	}
+	switch cfg.Caddy.Driver {
+	case "":
+		cfg.Caddy.Driver = "caddyfile"
+	case "caddyfile":
+	case "json":
+		if cfg.Caddy.BaseConfig == "" {
+			return nil, fmt.Errorf("caddy.baseConfig is required for the json driver")
+		}
+		if cfg.Caddy.Server == "" {
+			cfg.Caddy.Server = "srv0"
+		}
+	case "nginx":
+		if cfg.Nginx.Config == "" || cfg.Nginx.PidFile == "" {
+			return nil, fmt.Errorf("nginx.config and nginx.pidFile are required for the nginx driver")
+		}
+		if len(cfg.Caddy.Instances) > 0 || cfg.Caddy.ReloadMode == "incremental" {
+			return nil, fmt.Errorf("caddy.instances and incremental reloads need a caddy driver")
+		}
+	case "exec":
+		if len(cfg.Exec.Command) == 0 {
+			return nil, fmt.Errorf("exec.command is required for the exec driver")
+		}
+		if len(cfg.Caddy.Instances) > 0 || cfg.Caddy.ReloadMode == "incremental" {
+			return nil, fmt.Errorf("caddy.instances and incremental reloads need a caddy driver")
+		}
+		if cfg.Exec.Timeout == 0 {
+			cfg.Exec.Timeout = 30 * time.Second
+		}
+		if cfg.Exec.SiteExtension == "" {
+			cfg.Exec.SiteExtension = ".conf"
+		}
+	default:
+		return nil, fmt.Errorf("caddy.driver must be caddyfile, json, nginx or exec, got %q", cfg.Caddy.Driver)
+	}
+	switch cfg.Caddy.ReloadMode {
+	case "":
+		cfg.Caddy.ReloadMode = "full"
+	case "full", "incremental":
+	default:
+		return nil, fmt.Errorf("caddy.reloadMode must be full or incremental, got %q", cfg.Caddy.ReloadMode)
+	}
+	for i, in := range cfg.Caddy.Instances {
+		if in.Admin == "" {
+			return nil, fmt.Errorf("caddy.instances[%d]: admin is required", i)
+		}
+		if in.Name == "" {
+			cfg.Caddy.Instances[i].Name = in.Admin
+		}
+	}
+	switch cfg.Rollout.Strategy {
+	case "":
+		cfg.Rollout.Strategy = "all"
+	case "all", "canary":
+	default:
+		return nil, fmt.Errorf("rollout.strategy must be all or canary, got %q", cfg.Rollout.Strategy)
+	}
+	switch {
+	case cfg.Rollout.Soak == 0:
+		cfg.Rollout.Soak = 20 * time.Second
+	case cfg.Rollout.Soak < 0 || cfg.Rollout.Soak > maxSoak:
+		return nil, fmt.Errorf("rollout.soak must be at most %s, got %s", maxSoak, cfg.Rollout.Soak)
+	}
+	for stage, list := range map[string][]HookConfig{"pre": cfg.Hooks.Pre, "post": cfg.Hooks.Post} {
+		for i, h := range list {
+			if (len(h.Command) == 0) == (h.URL == "") {
+				return nil, fmt.Errorf("hooks.%s[%d]: set either command or url", stage, i)
+			}
+			if h.Name == "" {
+				list[i].Name = fmt.Sprintf("%s-%d", stage, i+1)
+			}
+		}
+	}
 	if cfg.GeoIP.DatabaseDir == "" {
 		cfg.GeoIP.DatabaseDir = "/usr/share/GeoIP"
 	}
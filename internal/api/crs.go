@@ -0,0 +1,300 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Stack-Dash/waf-admin/internal/crs"
+	"github.com/Stack-Dash/waf-admin/internal/domain"
+	"github.com/Stack-Dash/waf-admin/internal/iplist"
+	"github.com/Stack-Dash/waf-admin/internal/revision"
+)
+
+// maxTarball limits uploaded CRS release tarballs.
+const maxTarball = 64 << 20
+
+func (s *Server) listCRSVersions(w http.ResponseWriter, r *http.Request) {
+	vs, err := s.crs.Versions()
+	writeJSON(w, vs, err)
+}
+
+type installCRSReq struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+}
+
+// installCRS installs a CRS release tarball, either uploaded as the
+// request body (Content-Type application/gzip, ?version= optional) or
+// read from a path on the waf-admin host.
+func (s *Server) installCRS(w http.ResponseWriter, r *http.Request) {
+	var (
+		src     io.Reader
+		version string
+	)
+	switch r.Header.Get("Content-Type") {
+	case "application/gzip", "application/x-gzip", "application/octet-stream":
+		src = http.MaxBytesReader(w, r.Body, maxTarball)
+		version = r.URL.Query().Get("version")
+	default:
+		var req installCRSReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+			writeErr(w, 400, "invalid request: need path or a gzip body")
+			return
+		}
+		f, err := os.Open(req.Path)
+		if err != nil {
+			writeErr(w, 400, "open tarball: "+err.Error())
+			return
+		}
+		defer f.Close()
+		src, version = f, req.Version
+	}
+	v, err := s.crs.Install(src, version)
+	switch {
+	case errors.Is(err, crs.ErrExists):
+		writeErr(w, 409, err.Error())
+	case err != nil:
+		writeErr(w, 400, "install failed: "+err.Error())
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(201)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+}
+
+func (s *Server) deleteCRSVersion(w http.ResponseWriter, r *http.Request) {
+	version := chi.URLParam(r, "version")
+	pins, err := s.crsPins(r.Context())
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	var users []string
+	for site, v := range pins {
+		if v == version {
+			users = append(users, site)
+		}
+	}
+	if len(users) > 0 {
+		writeErr(w, 409, "crs version is pinned by sites: "+strings.Join(users, ", "))
+		return
+	}
+	if err := s.crs.Remove(version); err != nil {
+		if errors.Is(err, crs.ErrNotFound) {
+			writeErr(w, 404, err.Error())
+			return
+		}
+		writeErr(w, 500, err.Error())
+		return
+	}
+	writeJSON(w, map[string]any{"ok": true}, nil)
+}
+
+type siteCRS struct {
+	Version string `json:"version"`
+	Setup   string `json:"setup,omitempty"`
+}
+
+func (s *Server) getSiteCRS(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	conf, err := s.store.Read(r.Context(), s.crsPath(site, crs.ConfFile))
+	if err != nil {
+		writeErr(w, 404, "site has no crs version pinned")
+		return
+	}
+	setup, _ := s.store.Read(r.Context(), s.crsPath(site, crs.SetupFile))
+	writeJSON(w, siteCRS{Version: crs.PinnedVersion(conf), Setup: string(setup)}, nil)
+}
+
+// putSiteCRS pins a CRS version for a site and optionally replaces its
+// crs-setup.conf. Without setup the site keeps its current overrides, or
+// starts from the release's crs-setup.conf.example.
+func (s *Server) putSiteCRS(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	var req siteCRS
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Version == "" {
+		writeErr(w, 400, "invalid request: version required")
+		return
+	}
+	if req.Setup != "" && lintRejected(w, req.Setup) {
+		return
+	}
+	s.pinCRS(w, r, site, req, "crs-pin")
+}
+
+type upgradeCRSReq struct {
+	Version string `json:"version"`
+}
+
+// upgradeSiteCRS moves a site to another installed CRS version, keeping its
+// crs-setup.conf. A failed validate or reload restores the previous pin.
+func (s *Server) upgradeSiteCRS(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	var req upgradeCRSReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Version == "" {
+		writeErr(w, 400, "invalid request: version required")
+		return
+	}
+	conf, err := s.store.Read(r.Context(), s.crsPath(site, crs.ConfFile))
+	if err != nil {
+		writeErr(w, 409, "site has no crs version pinned")
+		return
+	}
+	if crs.PinnedVersion(conf) == req.Version {
+		writeErr(w, 409, "site already uses crs "+req.Version)
+		return
+	}
+	s.pinCRS(w, r, site, siteCRS{Version: req.Version}, "crs-upgrade")
+}
+
+// pinCRS writes crs.conf and moves the site's conditional exclusions and
+// IP list rules to crs.EarlyDir, which crs.conf loads ahead of the CRS
+// rules.
+func (s *Server) pinCRS(w http.ResponseWriter, r *http.Request, site string, req siteCRS, op string) {
+	ctx := r.Context()
+	s.managedMu.Lock()
+	defer s.managedMu.Unlock()
+	if _, err := s.crs.Get(req.Version); err != nil {
+		writeErr(w, 404, "crs version "+req.Version+" not installed")
+		return
+	}
+	var prev string
+	if b, err := s.store.Read(ctx, s.crsPath(site, crs.ConfFile)); err == nil {
+		prev = crs.PinnedVersion(b)
+	}
+	siteDir := filepath.Join(s.driver.LayoutRulesRoot(), site)
+	if err := s.store.MkdirAll(ctx, siteDir, 0o755); err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	var changes []fileChange
+	cur, err := s.store.Read(ctx, s.crsPath(site, crs.SetupFile))
+	setup := []byte(req.Setup)
+	switch {
+	case req.Setup == "" && err == nil:
+		// keep the site's overrides
+	case req.Setup == "":
+		if setup, err = s.crs.SetupExample(req.Version); err != nil {
+			writeErr(w, 500, "read crs-setup.conf.example: "+err.Error())
+			return
+		}
+		fallthrough
+	case string(setup) != string(cur):
+		changes = append(changes, fileChange{Key: crsKey(site, crs.SetupFile), Path: s.crsPath(site, crs.SetupFile), Data: setup})
+	}
+	list, err := s.loadExclusions(ctx, site)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	exc, err := s.exclusionFiles(ctx, site, list, true)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	changes = append(changes, exc...)
+	if _, err := s.store.Read(ctx, s.rulePath(site, iplist.RuleFile)); err == nil {
+		ipl, err := s.ipListRuleFiles(ctx, site, true)
+		if err != nil {
+			writeErr(w, 500, err.Error())
+			return
+		}
+		changes = append(changes, ipl...)
+	}
+	changes = append(changes, fileChange{Key: crsKey(site, crs.ConfFile), Path: s.crsPath(site, crs.ConfFile), Data: s.crs.RenderConf(siteDir, req.Version)})
+	revs, err := s.applyChanges(ctx, changes, revision.Revision{Author: author(r), Op: op})
+	if err != nil {
+		writeApplyErr(w, err)
+		return
+	}
+	resp := map[string]any{"ok": true, "version": req.Version, "previousVersion": prev, "revision": revs[len(revs)-1].Number}
+	if snippet, err := s.store.Read(ctx, s.sitePath(site)); err != nil || !strings.Contains(string(snippet), s.crsPath(site, crs.ConfFile)) {
+		resp["warning"] = "site snippet does not include " + s.crsPath(site, crs.ConfFile)
+	}
+	writeJSON(w, withResults(r, resp), nil)
+}
+
+// siteEarlyDir reports whether site has a pinned CRS, whose crs.conf loads
+// crs.EarlyDir. A crs.conf rendered before crs.EarlyDir existed is rendered
+// again by the returned changes.
+func (s *Server) siteEarlyDir(ctx context.Context, site string) (bool, []fileChange) {
+	conf, err := s.store.Read(ctx, s.crsPath(site, crs.ConfFile))
+	if err != nil {
+		return false, nil
+	}
+	siteDir := filepath.Join(s.driver.LayoutRulesRoot(), site)
+	if fresh := s.crs.RenderConf(siteDir, crs.PinnedVersion(conf)); !bytes.Equal(fresh, conf) {
+		return true, []fileChange{{Key: crsKey(site, crs.ConfFile), Path: s.crsPath(site, crs.ConfFile), Data: fresh}}
+	}
+	return true, nil
+}
+
+func (s *Server) crsPath(site, file string) string {
+	return filepath.Join(s.driver.LayoutRulesRoot(), site, file)
+}
+
+func crsKey(site, file string) string { return "crs/" + site + "/" + file }
+
+// crsPins returns the CRS version pinned by each site.
+func (s *Server) crsPins(ctx context.Context) (map[string]string, error) {
+	pins := map[string]string{}
+	for _, site := range s.ruleSites(ctx) {
+		if b, err := s.store.Read(ctx, s.crsPath(site, crs.ConfFile)); err == nil {
+			if v := crs.PinnedVersion(b); v != "" {
+				pins[site] = v
+			}
+		}
+	}
+	return pins, nil
+}
+
+// ruleIndex indexes every site's rule files together with the CRS release
+// it pins. pins overrides the stored pin of individual sites; an empty
+// version means no CRS.
+func (s *Server) ruleIndex(ctx context.Context, pins map[string]string) (*domain.RuleIndex, map[string][]string, error) {
+	ix, err := domain.BuildRuleIndex(ctx, s.driver, s.store)
+	if err != nil {
+		return nil, nil, err
+	}
+	stored, err := s.crsPins(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for site, v := range pins {
+		stored[site] = v
+	}
+	crsFiles := map[string][]string{}
+	for site, v := range stored {
+		if v == "" {
+			continue
+		}
+		files, err := s.crs.Files(v)
+		if err != nil {
+			// a pin to a missing release fails validation on its own
+			continue
+		}
+		crsFiles[site] = ix.SetCRS(site, v, files)
+	}
+	return ix, crsFiles, nil
+}
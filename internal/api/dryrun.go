@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/Stack-Dash/waf-admin/internal/diff"
+	"github.com/Stack-Dash/waf-admin/internal/render"
+)
+
+type dryRunResp struct {
+	Valid      bool     `json:"valid"`
+	Error      string   `json:"error,omitempty"`
+	Path       string   `json:"path"`
+	Diff       string   `json:"diff"`
+	ConfigDiff string   `json:"configDiff,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+func isDryRun(r *http.Request) bool { return r.URL.Query().Get("dryRun") == "true" }
+
+// dryRun validates content as the new version of path against a scratch
+// copy of the layout and reports the file diff and the adapted config
+// diff. Live files are not touched and nothing is reloaded.
+func (s *Server) dryRun(w http.ResponseWriter, r *http.Request, path string, content []byte) {
+	dr, ok := s.driver.(render.DryRunner)
+	if !ok {
+		writeErr(w, 501, "dry run not supported by driver")
+		return
+	}
+	cur, _ := s.store.Read(r.Context(), path)
+	res, err := dr.DryRun(r.Context(), map[string][]byte{path: content})
+	if err != nil {
+		writeErr(w, 500, "dry run failed: "+err.Error())
+		return
+	}
+	out := dryRunResp{
+		Valid:    res.Err == nil,
+		Path:     path,
+		Diff:     diff.Unified("a"+path, "b"+path, string(cur), string(content)),
+		Warnings: res.Warnings,
+	}
+	if res.Err != nil {
+		out.Error = res.Err.Error()
+	} else {
+		out.ConfigDiff = diff.Unified("current.json", "candidate.json", string(res.Current), string(res.Candidate))
+	}
+	writeJSON(w, out, nil)
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Stack-Dash/waf-admin/internal/auditlog"
+	"github.com/Stack-Dash/waf-admin/internal/domain"
+)
+
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+	// maxEventOffset bounds paging, as the store keeps offset+limit
+	// matches while it reads a day; narrow from/to to page further back.
+	maxEventOffset = 10000
+)
+
+// listEvents queries ingested audit events, newest first. Filters:
+// site, ruleId, ip (address or CIDR), action, from and to (RFC 3339);
+// paging with limit and offset.
+func (s *Server) listEvents(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		writeErr(w, 501, "audit log ingestion is not enabled")
+		return
+	}
+	q := r.URL.Query()
+	f := auditlog.Filter{Site: q.Get("site"), Instance: q.Get("instance"), IP: q.Get("ip"), Action: q.Get("action")}
+	var err error
+	if v := q.Get("ruleId"); v != "" {
+		if f.RuleID, err = strconv.Atoi(v); err != nil {
+			writeErr(w, 400, "invalid ruleId")
+			return
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			writeErr(w, 400, "invalid from, expected RFC 3339")
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			writeErr(w, 400, "invalid to, expected RFC 3339")
+			return
+		}
+	}
+	limit, offset := defaultEventLimit, 0
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxEventLimit {
+			writeErr(w, 400, "invalid limit, must be 1-"+strconv.Itoa(maxEventLimit))
+			return
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 || offset > maxEventOffset {
+			writeErr(w, 400, "invalid offset, must be 0-"+strconv.Itoa(maxEventOffset))
+			return
+		}
+	}
+	events, more, err := s.events.Query(f, offset, limit)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	resp := map[string]any{"events": events, "offset": offset, "limit": limit, "more": more}
+	if more {
+		resp["nextOffset"] = offset + len(events)
+	}
+	writeJSON(w, resp, nil)
+}
+
+// SiteForHost returns the site whose snippet serves host. The mapping is
+// rebuilt from the snippets at most once a minute.
+func (s *Server) SiteForHost(host string) string {
+	s.hostsMu.Lock()
+	defer s.hostsMu.Unlock()
+	s.refreshHosts()
+	return s.hosts[host]
+}
+
+// KnownSite reports whether site has a snippet, as of the last rebuild of
+// the SiteForHost mapping.
+func (s *Server) KnownSite(site string) bool {
+	s.hostsMu.Lock()
+	defer s.hostsMu.Unlock()
+	s.refreshHosts()
+	return s.sites[site]
+}
+
+// refreshHosts rebuilds hosts and sites if they are older than a minute.
+// The caller holds hostsMu.
+func (s *Server) refreshHosts() {
+	if time.Since(s.hostsAt) <= time.Minute {
+		return
+	}
+	ctx := context.Background()
+	if hosts, err := domain.SiteHosts(ctx, s.driver, s.store); err == nil {
+		s.hosts = hosts
+	}
+	if list, err := domain.ListSites(ctx, s.driver, s.store); err == nil {
+		s.sites = make(map[string]bool, len(list))
+		for _, si := range list {
+			s.sites[si.Name] = true
+		}
+	}
+	s.hostsAt = time.Now()
+}
+
+func (s *Server) getEvent(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		writeErr(w, 501, "audit log ingestion is not enabled")
+		return
+	}
+	ev, ok, err := s.events.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	if !ok {
+		writeErr(w, 404, "event not found")
+		return
+	}
+	writeJSON(w, ev, nil)
+}
@@ -0,0 +1,413 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Stack-Dash/waf-admin/internal/auditlog"
+	"github.com/Stack-Dash/waf-admin/internal/crs"
+	"github.com/Stack-Dash/waf-admin/internal/exclusion"
+	"github.com/Stack-Dash/waf-admin/internal/geoip"
+	"github.com/Stack-Dash/waf-admin/internal/iplist"
+	"github.com/Stack-Dash/waf-admin/internal/revision"
+	"github.com/Stack-Dash/waf-admin/internal/seclang"
+)
+
+// managedPrefix marks rule files that waf-admin renders from structured
+// state. They cannot be written through the rule file endpoints.
+const managedPrefix = "zz-waf-admin-"
+
+func managedRuleFile(file string) bool { return strings.HasPrefix(file, managedPrefix) }
+
+// managedRuleIDs are the inclusive rule ID ranges of the rules waf-admin
+// renders itself. Exclusions cannot target them: those rules are changed
+// through their own endpoints, and their files may load after the
+// exclusions.
+var managedRuleIDs = [][2]int{
+	{exclusion.IDBase, exclusion.IDBase + exclusion.IDRange - 1},
+	{iplist.IDBase, iplist.IDBase + 3},
+	{geoip.IDBase, geoip.IDBase + 9},
+}
+
+func managedRuleID(id int) bool {
+	for _, r := range managedRuleIDs {
+		if id >= r[0] && id <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) exclusionsPath(site string) string {
+	return filepath.Join(s.driver.LayoutRulesRoot(), site, exclusion.StateFile)
+}
+
+func exclusionsKey(site string) string { return "exclusions/" + site }
+
+func (s *Server) loadExclusions(ctx context.Context, site string) ([]exclusion.Exclusion, error) {
+	b, err := s.store.Read(ctx, s.exclusionsPath(site))
+	if err != nil {
+		return []exclusion.Exclusion{}, nil
+	}
+	return exclusion.Load(b)
+}
+
+func (s *Server) earlyPath(site, file string) string {
+	return filepath.Join(s.driver.LayoutRulesRoot(), site, crs.EarlyDir, file)
+}
+
+func earlyKey(site, file string) string { return "early/" + site + "/" + file }
+
+// exclusionFiles renders list into the managed rule file changes of site.
+// With pinned, i.e. when the site's crs.conf loads crs.EarlyDir, the
+// conditional exclusions go to the early file.
+func (s *Server) exclusionFiles(ctx context.Context, site string, list []exclusion.Exclusion, pinned bool) ([]fileChange, error) {
+	early, late, err := exclusion.Render(site, list, time.Now(), pinned)
+	if err != nil {
+		return nil, err
+	}
+	// rendering bugs must not reach Caddy as opaque validate errors
+	for _, conf := range [][]byte{early, late} {
+		if issues := seclang.Lint(string(conf)); seclang.HasErrors(issues) {
+			return nil, errors.New("rendered exclusions do not lint: " + issues[0].String())
+		}
+	}
+	if err := s.store.MkdirAll(ctx, filepath.Join(s.driver.LayoutRulesRoot(), site, "rules"), 0o755); err != nil {
+		return nil, err
+	}
+	changes := []fileChange{{Key: ruleKey(site, exclusion.RuleFile), Path: s.rulePath(site, exclusion.RuleFile), Data: late, Managed: true}}
+	if pinned {
+		if err := s.store.MkdirAll(ctx, filepath.Join(s.driver.LayoutRulesRoot(), site, crs.EarlyDir), 0o755); err != nil {
+			return nil, err
+		}
+		changes = append(changes, fileChange{Key: earlyKey(site, exclusion.EarlyFile), Path: s.earlyPath(site, exclusion.EarlyFile), Data: early})
+	}
+	return changes, nil
+}
+
+// saveExclusions writes the state file together with the rendered rule
+// files and applies them as one change.
+func (s *Server) saveExclusions(ctx context.Context, site string, list []exclusion.Exclusion, meta revision.Revision) (revision.Revision, error) {
+	changes := []fileChange{{Key: exclusionsKey(site), Path: s.exclusionsPath(site), Data: exclusion.Marshal(list)}}
+	pinned, refresh := s.siteEarlyDir(ctx, site)
+	changes = append(changes, refresh...)
+	files, err := s.exclusionFiles(ctx, site, list, pinned)
+	if err != nil {
+		return revision.Revision{}, err
+	}
+	revs, err := s.applyChanges(ctx, append(changes, files...), meta)
+	if err != nil {
+		return revision.Revision{}, err
+	}
+	return revs[0], nil
+}
+
+func (s *Server) listExclusions(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	list, err := s.loadExclusions(r.Context(), site)
+	writeJSON(w, list, err)
+}
+
+func (s *Server) getExclusion(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	list, err := s.loadExclusions(r.Context(), site)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	for _, e := range list {
+		if e.ID == chi.URLParam(r, "id") {
+			writeJSON(w, e, nil)
+			return
+		}
+	}
+	writeErr(w, 404, "exclusion not found")
+}
+
+// decodeExclusion reads and validates an exclusion from the request body.
+func decodeExclusion(w http.ResponseWriter, r *http.Request) (exclusion.Exclusion, bool) {
+	var e exclusion.Exclusion
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		writeErr(w, 400, "invalid json")
+		return e, false
+	}
+	if err := e.Validate(); err != nil {
+		writeErr(w, 400, err.Error())
+		return e, false
+	}
+	if managedRuleID(e.RuleID) {
+		writeErr(w, 400, fmt.Sprintf("rule %d is managed by waf-admin and cannot be excluded", e.RuleID))
+		return e, false
+	}
+	if e.Expired(time.Now()) {
+		writeErr(w, 400, "expires is in the past")
+		return e, false
+	}
+	return e, true
+}
+
+func (s *Server) createExclusion(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	e, ok := decodeExclusion(w, r)
+	if !ok {
+		return
+	}
+	e.ID, e.Author, e.Created = exclusion.NewID(), author(r), time.Now().UTC()
+
+	s.managedMu.Lock()
+	defer s.managedMu.Unlock()
+	list, err := s.loadExclusions(r.Context(), site)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	list = append(list, e)
+	rev, err := s.saveExclusions(r.Context(), site, list, revision.Revision{Author: author(r), Op: "exclusion-add"})
+	if err != nil {
+		writeApplyErr(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(withResults(r, map[string]any{"exclusion": e, "revision": rev.Number}))
+}
+
+func (s *Server) updateExclusion(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	e, ok := decodeExclusion(w, r)
+	if !ok {
+		return
+	}
+	s.managedMu.Lock()
+	defer s.managedMu.Unlock()
+	list, err := s.loadExclusions(r.Context(), site)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	i := exclusionIndex(list, chi.URLParam(r, "id"))
+	if i < 0 {
+		writeErr(w, 404, "exclusion not found")
+		return
+	}
+	e.ID, e.Created, e.Author = list[i].ID, list[i].Created, author(r)
+	list[i] = e
+	rev, err := s.saveExclusions(r.Context(), site, list, revision.Revision{Author: author(r), Op: "exclusion-update"})
+	if err != nil {
+		writeApplyErr(w, err)
+		return
+	}
+	writeJSON(w, withResults(r, map[string]any{"exclusion": e, "revision": rev.Number}), nil)
+}
+
+func (s *Server) deleteExclusion(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	s.managedMu.Lock()
+	defer s.managedMu.Unlock()
+	list, err := s.loadExclusions(r.Context(), site)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	i := exclusionIndex(list, chi.URLParam(r, "id"))
+	if i < 0 {
+		writeErr(w, 404, "exclusion not found")
+		return
+	}
+	list = append(list[:i], list[i+1:]...)
+	rev, err := s.saveExclusions(r.Context(), site, list, revision.Revision{Author: author(r), Op: "exclusion-delete"})
+	if err != nil {
+		writeApplyErr(w, err)
+		return
+	}
+	writeJSON(w, withResults(r, map[string]any{"ok": true, "revision": rev.Number}), nil)
+}
+
+// suggestRequest is the optional body of suggest-exclusion. Site overrides
+// the site the event was attributed to; Reason and Expires apply to every
+// suggested exclusion.
+type suggestRequest struct {
+	Apply   bool       `json:"apply"`
+	Site    string     `json:"site"`
+	Reason  string     `json:"reason"`
+	Expires *time.Time `json:"expires"`
+}
+
+// suggestExclusion proposes exclusions for the rules that matched a stored
+// event and, with apply, adds them to the site's exclusions.
+func (s *Server) suggestExclusion(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		writeErr(w, 501, "audit log ingestion is not enabled")
+		return
+	}
+	var req suggestRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, 400, "invalid json")
+			return
+		}
+	}
+	ev, ok, err := s.events.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	if !ok {
+		writeErr(w, 404, "event not found")
+		return
+	}
+	site := ev.Site
+	if req.Site != "" {
+		site = req.Site
+	} else if site == auditlog.UnknownSite {
+		writeErr(w, 422, "event is not attributed to a configured site, pass site")
+		return
+	}
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	list := exclusion.Suggest(ev)
+	// waf-admin's own rules are changed through their own endpoints
+	kept := list[:0]
+	for _, e := range list {
+		if !managedRuleID(e.RuleID) {
+			kept = append(kept, e)
+		}
+	}
+	list = kept
+	if len(list) == 0 {
+		writeErr(w, 422, "event has no rule matches to exclude")
+		return
+	}
+	for i := range list {
+		if req.Reason != "" {
+			list[i].Reason = req.Reason
+		}
+		list[i].ID, list[i].Author, list[i].Created = exclusion.NewID(), author(r), time.Now().UTC()
+		list[i].Expires = req.Expires
+	}
+	if !req.Apply {
+		_, conf, err := exclusion.Render(site, list, time.Now(), false)
+		if err != nil {
+			writeErr(w, 500, err.Error())
+			return
+		}
+		writeJSON(w, map[string]any{"event": ev.ID, "site": site, "exclusions": list, "seclang": string(conf)}, nil)
+		return
+	}
+
+	if req.Expires != nil && !req.Expires.After(time.Now()) {
+		writeErr(w, 400, "expires is in the past")
+		return
+	}
+	if _, err := s.store.Read(r.Context(), s.sitePath(site)); err != nil {
+		writeErr(w, 422, "event is not attributed to a configured site, pass site")
+		return
+	}
+	s.managedMu.Lock()
+	defer s.managedMu.Unlock()
+	current, err := s.loadExclusions(r.Context(), site)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	added := list[:0]
+	for _, e := range list {
+		if !coveredBy(current, e) {
+			added = append(added, e)
+		}
+	}
+	if len(added) == 0 {
+		writeErr(w, 409, "the suggested exclusions already exist")
+		return
+	}
+	rev, err := s.saveExclusions(r.Context(), site, append(current, added...), revision.Revision{Author: author(r), Op: "exclusion-add"})
+	if err != nil {
+		writeApplyErr(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(withResults(r, map[string]any{"event": ev.ID, "site": site, "exclusions": added, "revision": rev.Number}))
+}
+
+// coveredBy reports whether list already has an exclusion with the same
+// scope as e that does not expire.
+func coveredBy(list []exclusion.Exclusion, e exclusion.Exclusion) bool {
+	for _, c := range list {
+		if c.RuleID == e.RuleID && c.Tag == e.Tag && c.Target == e.Target && c.Path == e.Path && c.Method == e.Method && c.Expires == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func exclusionIndex(list []exclusion.Exclusion, id string) int {
+	for i, e := range list {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// PruneExpired drops expired exclusions and IP list entries and applies
+// the result. It is meant to run periodically.
+func (s *Server) PruneExpired(ctx context.Context) error {
+	s.managedMu.Lock()
+	defer s.managedMu.Unlock()
+	var errs []error
+	now := time.Now()
+	for _, site := range s.ruleSites(ctx) {
+		list, err := s.loadExclusions(ctx, site)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		kept := list[:0:0]
+		for _, e := range list {
+			if !e.Expired(now) {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == len(list) {
+			continue
+		}
+		if _, err := s.saveExclusions(ctx, site, kept, revision.Revision{Author: "waf-admin", Op: "expire"}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := s.pruneIPLists(ctx, now); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
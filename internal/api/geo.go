@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Stack-Dash/waf-admin/internal/geoip"
+	"github.com/Stack-Dash/waf-admin/internal/revision"
+	"github.com/Stack-Dash/waf-admin/internal/seclang"
+)
+
+func (s *Server) geoPolicyPath(site string) string {
+	return filepath.Join(s.driver.LayoutRulesRoot(), site, geoip.PolicyFile)
+}
+
+func geoPolicyKey(site string) string { return "geo-policy/" + site }
+
+func (s *Server) getGeoPolicy(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	b, err := s.store.Read(r.Context(), s.geoPolicyPath(site))
+	if err != nil {
+		writeErr(w, 404, "site has no geo policy")
+		return
+	}
+	p, err := geoip.LoadPolicy(b)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	w.Header().Set("ETag", etag(b))
+	writeJSON(w, p, nil)
+}
+
+// putGeoPolicy replaces the site's geo policy. The rendered rules rely on
+// the GeoIP database Caddy loads, so they are refused while the database
+// in geoip.databaseDir is missing or unreadable.
+func (s *Server) putGeoPolicy(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	var p geoip.Policy
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		writeErr(w, 400, "invalid json")
+		return
+	}
+	if err := p.Validate(); err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	if err := geoip.Check(s.cfg.GeoIP.DatabasePath()); err != nil {
+		writeErr(w, 409, "geoip database is not available: "+err.Error())
+		return
+	}
+	p.Author, p.Updated = author(r), time.Now().UTC()
+
+	conf := geoip.RenderPolicy(site, p)
+	if issues := seclang.Lint(string(conf)); seclang.HasErrors(issues) {
+		writeErr(w, 500, "rendered geo policy does not lint: "+issues[0].String())
+		return
+	}
+	if err := s.store.MkdirAll(r.Context(), filepath.Join(s.driver.LayoutRulesRoot(), site, "rules"), 0o755); err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	state := geoip.MarshalPolicy(p)
+	changes := []fileChange{
+		{Key: geoPolicyKey(site), Path: s.geoPolicyPath(site), Data: state, IfMatch: r.Header.Get("If-Match")},
+		{Key: ruleKey(site, geoip.RuleFile), Path: s.rulePath(site, geoip.RuleFile), Data: conf, Managed: true},
+	}
+	revs, err := s.applyChanges(r.Context(), changes, revision.Revision{Author: author(r), Op: "geo-policy"})
+	if err != nil {
+		writeApplyErr(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(state))
+	writeJSON(w, withResults(r, map[string]any{"policy": p, "revision": revs[0].Number}), nil)
+}
+
+func (s *Server) deleteGeoPolicy(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	changes := []fileChange{
+		{Key: geoPolicyKey(site), Path: s.geoPolicyPath(site), Delete: true, IfMatch: r.Header.Get("If-Match")},
+		{Key: ruleKey(site, geoip.RuleFile), Path: s.rulePath(site, geoip.RuleFile), Delete: true, Managed: true},
+	}
+	revs, err := s.applyChanges(r.Context(), changes, revision.Revision{Author: author(r), Op: "geo-policy-delete"})
+	if err != nil {
+		writeApplyErr(w, err)
+		return
+	}
+	writeJSON(w, withResults(r, map[string]any{"ok": true, "revision": revs[0].Number}), nil)
+}
@@ -8,13 +8,20 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/log"
 
+	"github.com/Stack-Dash/waf-admin/internal/auditlog"
+	"github.com/Stack-Dash/waf-admin/internal/changeset"
+	"github.com/Stack-Dash/waf-admin/internal/crs"
 	"github.com/Stack-Dash/waf-admin/internal/domain"
+	"github.com/Stack-Dash/waf-admin/internal/hooks"
 	"github.com/Stack-Dash/waf-admin/internal/reload"
 	"github.com/Stack-Dash/waf-admin/internal/render"
+	"github.com/Stack-Dash/waf-admin/internal/revision"
 	"github.com/Stack-Dash/waf-admin/internal/storage"
 )
 
@@ -28,13 +35,48 @@ type Server struct {
 	store  storage.Storage
 	driver render.Driver
 	rel    reload.Reloader
+	revs   *revision.Store
+	crs    *crs.Manager
+	events *auditlog.Store
 	http   *http.Server
+
+	changesets *changeset.Store
+
+	// applyMu serialises write-validate-reload-restore sequences.
+	applyMu sync.Mutex
+	// managedMu serialises read-modify-write of the structured state
+	// behind managed rule files.
+	managedMu sync.Mutex
+
+	preHooks, postHooks []hooks.Hook
+
+	hostsMu sync.Mutex
+	hosts   map[string]string
+	sites   map[string]bool
+	hostsAt time.Time
 }
 
 func NewServer(cfg *Config, st storage.Storage, dr render.Driver, rl reload.Reloader) *Server {
-	return &Server{cfg: cfg, store: st, driver: dr, rel: rl}
+	s := &Server{
+		cfg:        cfg,
+		store:      st,
+		driver:     dr,
+		rel:        rl,
+		revs:       revision.NewStore(cfg.History.Dir),
+		crs:        crs.NewManager(cfg.CRS.Dir),
+		changesets: changeset.NewStore(),
+		preHooks:   hookList(cfg.Hooks.Pre),
+		postHooks:  hookList(cfg.Hooks.Post),
+	}
+	if cfg.Audit.Enabled {
+		s.events = auditlog.NewStore(cfg.Audit.Dir, cfg.Audit.Retention)
+	}
+	return s
 }
 
+// Events returns the audit event store, nil unless audit.enabled is set.
+func (s *Server) Events() *auditlog.Store { return s.events }
+
 func (s *Server) Start() error {
 	s.http = &http.Server{Addr: s.cfg.Server.Bind, Handler: s.routes()}
 	log.Info().Str("bind", s.cfg.Server.Bind).Msg("starting waf-admin")
@@ -67,12 +109,12 @@ func (s *Server) getSite(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, 400, "invalid site name")
 		return
 	}
-	path := filepath.Join(s.driver.LayoutSites(), site+".caddy")
-	b, err := s.store.Read(r.Context(), path)
+	b, err := s.store.Read(r.Context(), s.sitePath(site))
 	if err != nil {
 		writeErr(w, 404, "site not found")
 		return
 	}
+	w.Header().Set("ETag", etag(b))
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(200)
 	_, _ = w.Write(b)
@@ -93,31 +135,29 @@ func (s *Server) putSite(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, 400, "invalid content")
 		return
 	}
-	path := filepath.Join(s.driver.LayoutSites(), site+".caddy")
-	orig, err := s.store.Read(r.Context(), path)
-	hadOrig := err == nil
-	if err := s.store.WriteAtomic(r.Context(), path, []byte(req.Content), 0o644); err != nil {
-		writeErr(w, 500, err.Error())
+	if isDryRun(r) {
+		s.dryRun(w, r, s.sitePath(site), []byte(req.Content))
 		return
 	}
 	if err := s.store.MkdirAll(r.Context(), filepath.Join(s.driver.LayoutRulesRoot(), site, "rules"), 0o755); err != nil {
 		writeErr(w, 500, err.Error())
 		return
 	}
-	if err := s.applyNow(r.Context()); err != nil {
-		if hadOrig {
-			if restoreErr := s.store.WriteAtomic(context.Background(), path, orig, 0o644); restoreErr != nil {
-				log.Error().Err(restoreErr).Str("path", path).Msg("restore site failed")
-			}
-		} else {
-			if delErr := s.store.Delete(context.Background(), path); delErr != nil {
-				log.Error().Err(delErr).Str("path", path).Msg("delete new site failed")
-			}
-		}
-		writeErr(w, 400, "validate/apply failed: "+err.Error())
+	ch := fileChange{Key: siteKey(site), Path: s.sitePath(site), Data: []byte(req.Content), IfMatch: r.Header.Get("If-Match")}
+	s.managedMu.Lock()
+	defer s.managedMu.Unlock()
+	ipl, err := s.ipListInstall(r.Context(), site)
+	if err != nil {
+		writeErr(w, 500, err.Error())
 		return
 	}
-	writeJSON(w, map[string]any{"ok": true}, nil)
+	revs, err := s.applyChanges(r.Context(), append([]fileChange{ch}, ipl...), revision.Revision{Author: author(r), Op: "put"})
+	if err != nil {
+		writeApplyErr(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(ch.Data))
+	writeJSON(w, withResults(r, map[string]any{"ok": true, "revision": revs[0].Number}), nil)
 }
 
 func (s *Server) deleteSite(w http.ResponseWriter, r *http.Request) {
@@ -126,13 +166,20 @@ func (s *Server) deleteSite(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, 400, "invalid site name")
 		return
 	}
-	path := filepath.Join(s.driver.LayoutSites(), site+".caddy")
-	_ = s.store.Delete(r.Context(), path)
-	if err := s.applyNow(r.Context()); err != nil {
-		writeErr(w, 400, "validate/apply failed: "+err.Error())
+	ch := fileChange{Key: siteKey(site), Path: s.sitePath(site), Delete: true, IfMatch: r.Header.Get("If-Match")}
+	rev, err := s.applyChange(r.Context(), ch, revision.Revision{Author: author(r), Op: "delete"})
+	if err != nil {
+		writeApplyErr(w, err)
 		return
 	}
-	writeJSON(w, map[string]any{"ok": true}, nil)
+	writeJSON(w, withResults(r, map[string]any{"ok": true, "revision": rev.Number}), nil)
+}
+
+type ruleFileInfo struct {
+	Name    string    `json:"name"`
+	Enabled bool      `json:"enabled"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
 }
 
 func (s *Server) listRules(w http.ResponseWriter, r *http.Request) {
@@ -147,15 +194,20 @@ func (s *Server) listRules(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, 404, "no rules")
 		return
 	}
-	files := make([]string, 0, len(ents))
+	files := make([]ruleFileInfo, 0, len(ents))
 	for _, e := range ents {
 		if e.IsDir() {
 			continue
 		}
 		name := e.Name()
-		if fileNameRe.MatchString(name) {
-			files = append(files, name)
+		if !fileNameRe.MatchString(name) {
+			continue
+		}
+		info := ruleFileInfo{Name: name, Enabled: strings.HasSuffix(name, ".conf")}
+		if fi, err := e.Info(); err == nil {
+			info.Size, info.ModTime = fi.Size(), fi.ModTime()
 		}
+		files = append(files, info)
 	}
 	writeJSON(w, files, nil)
 }
@@ -167,12 +219,12 @@ func (s *Server) getRule(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, 400, "invalid name")
 		return
 	}
-	path := filepath.Join(s.driver.LayoutRulesRoot(), site, "rules", file)
-	b, err := s.store.Read(r.Context(), path)
+	b, err := s.store.Read(r.Context(), s.rulePath(site, file))
 	if err != nil {
 		writeErr(w, 404, "rule not found")
 		return
 	}
+	w.Header().Set("ETag", etag(b))
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(200)
 	_, _ = w.Write(b)
@@ -194,31 +246,26 @@ func (s *Server) putRule(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, 400, "invalid content")
 		return
 	}
-	path := filepath.Join(s.driver.LayoutRulesRoot(), site, "rules", file)
-	if err := s.store.MkdirAll(r.Context(), filepath.Dir(path), 0o755); err != nil {
-		writeErr(w, 500, err.Error())
+	if lintRejected(w, req.Content) {
 		return
 	}
-	orig, err := s.store.Read(r.Context(), path)
-	hadOrig := err == nil
-	if err := s.store.WriteAtomic(r.Context(), path, []byte(req.Content), 0o644); err != nil {
+	path := s.rulePath(site, file)
+	if isDryRun(r) {
+		s.dryRun(w, r, path, []byte(req.Content))
+		return
+	}
+	if err := s.store.MkdirAll(r.Context(), filepath.Dir(path), 0o755); err != nil {
 		writeErr(w, 500, err.Error())
 		return
 	}
-	if err := s.applyNow(r.Context()); err != nil {
-		if hadOrig {
-			if restoreErr := s.store.WriteAtomic(context.Background(), path, orig, 0o644); restoreErr != nil {
-				log.Error().Err(restoreErr).Str("path", path).Msg("restore rule failed")
-			}
-		} else {
-			if delErr := s.store.Delete(context.Background(), path); delErr != nil {
-				log.Error().Err(delErr).Str("path", path).Msg("delete new rule failed")
-			}
-		}
-		writeErr(w, 400, "validate/apply failed: "+err.Error())
+	ch := fileChange{Key: ruleKey(site, file), Path: path, Data: []byte(req.Content), IfMatch: r.Header.Get("If-Match")}
+	rev, err := s.applyChange(r.Context(), ch, revision.Revision{Author: author(r), Op: "put"})
+	if err != nil {
+		writeApplyErr(w, err)
 		return
 	}
-	writeJSON(w, map[string]any{"ok": true}, nil)
+	w.Header().Set("ETag", etag(ch.Data))
+	writeJSON(w, withResults(r, map[string]any{"ok": true, "revision": rev.Number}), nil)
 }
 
 func (s *Server) deleteRule(w http.ResponseWriter, r *http.Request) {
@@ -228,13 +275,53 @@ func (s *Server) deleteRule(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, 400, "invalid name")
 		return
 	}
-	path := filepath.Join(s.driver.LayoutRulesRoot(), site, "rules", file)
-	_ = s.store.Delete(r.Context(), path)
-	if err := s.applyNow(r.Context()); err != nil {
-		writeErr(w, 400, "validate/apply failed: "+err.Error())
+	ch := fileChange{Key: ruleKey(site, file), Path: s.rulePath(site, file), Delete: true, IfMatch: r.Header.Get("If-Match")}
+	rev, err := s.applyChange(r.Context(), ch, revision.Revision{Author: author(r), Op: "delete"})
+	if err != nil {
+		writeApplyErr(w, err)
 		return
 	}
-	writeJSON(w, map[string]any{"ok": true}, nil)
+	writeJSON(w, withResults(r, map[string]any{"ok": true, "revision": rev.Number}), nil)
+}
+
+func (s *Server) enableRule(w http.ResponseWriter, r *http.Request)  { s.toggleRule(w, r, true) }
+func (s *Server) disableRule(w http.ResponseWriter, r *http.Request) { s.toggleRule(w, r, false) }
+
+// toggleRule renames a rule file between name.conf and name.conf.disabled
+// and applies the result once.
+func (s *Server) toggleRule(w http.ResponseWriter, r *http.Request, enable bool) {
+	site := chi.URLParam(r, "site")
+	file := chi.URLParam(r, "file")
+	if !siteNameRe.MatchString(site) || !fileNameRe.MatchString(file) {
+		writeErr(w, 400, "invalid name")
+		return
+	}
+	disabled := strings.HasSuffix(file, ".disabled")
+	if disabled != enable {
+		if enable {
+			writeErr(w, 409, "rule file is already enabled")
+		} else {
+			writeErr(w, 409, "rule file is already disabled")
+		}
+		return
+	}
+	target, op := file+".disabled", "disable"
+	if enable {
+		target, op = strings.TrimSuffix(file, ".disabled"), "enable"
+	}
+	ch := fileChange{
+		Key:        ruleKey(site, target),
+		Path:       s.rulePath(site, target),
+		IfMatch:    r.Header.Get("If-Match"),
+		RenameFrom: s.rulePath(site, file),
+		RenameKey:  ruleKey(site, file),
+	}
+	rev, err := s.applyChange(r.Context(), ch, revision.Revision{Author: author(r), Op: op})
+	if err != nil {
+		writeApplyErr(w, err)
+		return
+	}
+	writeJSON(w, withResults(r, map[string]any{"ok": true, "file": target, "enabled": enable, "revision": rev.Number}), nil)
 }
 
 func (s *Server) validate(w http.ResponseWriter, r *http.Request) {
@@ -246,19 +333,45 @@ func (s *Server) validate(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) apply(w http.ResponseWriter, r *http.Request) {
-	if err := s.applyNow(r.Context()); err != nil {
-		writeErr(w, 400, err.Error())
+	s.applyMu.Lock()
+	err := s.applyNow(r.Context())
+	s.applyMu.Unlock()
+	if err != nil {
+		writeApplyErr(w, &applyError{err})
 		return
 	}
-	writeJSON(w, map[string]any{"ok": true}, nil)
+	s.runPostHooks(r.Context())
+	writeJSON(w, withResults(r, map[string]any{"ok": true}), nil)
 }
 
+// instances reports how the last reload went on each Caddy instance.
+func (s *Server) instances(w http.ResponseWriter, r *http.Request) {
+	fo, ok := s.rel.(*reload.FanOut)
+	if !ok {
+		writeErr(w, 404, "no caddy.instances configured")
+		return
+	}
+	writeJSON(w, fo.Results(), nil)
+}
+
+// applyNow runs the pre-apply hooks, validates, enforces the tests and
+// reloads. The caller holds applyMu and runs the post-apply hooks once it
+// has released it.
 func (s *Server) applyNow(ctx context.Context) error {
+	if len(s.preHooks) > 0 {
+		if err := hooks.RunPre(ctx, s.preHooks, hookEvent(ctx)); err != nil {
+			return err
+		}
+	}
 	if err := s.driver.Validate(ctx); err != nil {
 		return err
 	}
-	if err := s.rel.Reload(ctx); err != nil {
-		return err
+	if s.cfg.Tests.Enforce {
+		if err := s.checkTests(ctx); err != nil {
+			return err
+		}
 	}
-	return nil
+	err := s.rel.Reload(ctx)
+	s.recordInstances(ctx)
+	return err
 }
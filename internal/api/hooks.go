@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Stack-Dash/waf-admin/internal/execproto"
+	"github.com/Stack-Dash/waf-admin/internal/hooks"
+	"github.com/Stack-Dash/waf-admin/internal/reload"
+)
+
+func hookList(list []HookConfig) []hooks.Hook {
+	out := make([]hooks.Hook, len(list))
+	for i, h := range list {
+		out[i] = hooks.Hook{Name: h.Name, Command: h.Command, URL: h.URL, Headers: h.Headers, Timeout: h.Timeout}
+	}
+	return out
+}
+
+func hookEvent(ctx context.Context) hooks.Event {
+	return hooks.Event{Sites: reload.Sites(ctx), Paths: execproto.Paths(ctx)}
+}
+
+// runPostHooks runs the post-apply hooks, logs their results and records
+// them for the response.
+func (s *Server) runPostHooks(ctx context.Context) {
+	if len(s.postHooks) == 0 {
+		return
+	}
+	results := hooks.RunPost(ctx, s.postHooks, hookEvent(ctx))
+	for _, res := range results {
+		if res.OK {
+			log.Info().Str("hook", res.Name).Int64("ms", res.DurationMs).Msg("post-apply hook done")
+		} else {
+			log.Warn().Str("hook", res.Name).Str("error", res.Error).Str("output", res.Output).Msg("post-apply hook failed")
+		}
+	}
+	ar := resultsOf(ctx)
+	ar.hooks = append(ar.hooks, results...)
+}
@@ -0,0 +1,316 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Stack-Dash/waf-admin/internal/crs"
+	"github.com/Stack-Dash/waf-admin/internal/iplist"
+	"github.com/Stack-Dash/waf-admin/internal/revision"
+	"github.com/Stack-Dash/waf-admin/internal/seclang"
+)
+
+// IP lists exist per site and once globally; site "" means the global
+// lists throughout this file.
+
+func (s *Server) ipListDir(site string) string {
+	if site == "" {
+		return filepath.Join(s.driver.LayoutRulesRoot(), iplist.GlobalDir)
+	}
+	return filepath.Join(s.driver.LayoutRulesRoot(), site)
+}
+
+func ipListKey(site string) string {
+	if site == "" {
+		return "iplists-global"
+	}
+	return "iplists/" + site
+}
+
+func (s *Server) loadIPLists(ctx context.Context, site string) (iplist.Lists, error) {
+	b, err := s.store.Read(ctx, filepath.Join(s.ipListDir(site), iplist.StateFile))
+	if err != nil {
+		return iplist.Load(nil)
+	}
+	return iplist.Load(b)
+}
+
+// ipListFiles returns the state and data file changes for one scope.
+func (s *Server) ipListFiles(site string, l iplist.Lists, now time.Time) []fileChange {
+	dir := s.ipListDir(site)
+	changes := []fileChange{{Key: ipListKey(site), Path: filepath.Join(dir, iplist.StateFile), Data: iplist.Marshal(l)}}
+	for _, kind := range iplist.Kinds {
+		changes = append(changes, fileChange{
+			Key:  ipListKey(site) + "/" + iplist.DataFile(kind),
+			Path: filepath.Join(dir, iplist.DataFile(kind)),
+			Data: iplist.RenderData(*l.Get(kind), now),
+		})
+	}
+	return changes
+}
+
+// ipListRuleFiles returns the changes that install a site's rule file:
+// in crs.EarlyDir when the site loads it (see siteEarlyDir), replacing
+// the one in its rules directory, and in the rules directory otherwise.
+func (s *Server) ipListRuleFiles(ctx context.Context, site string, early bool) ([]fileChange, error) {
+	conf := iplist.RenderRules(site, s.ipListDir(""), s.ipListDir(site))
+	if issues := seclang.Lint(string(conf)); seclang.HasErrors(issues) {
+		return nil, errors.New("rendered ip list rules do not lint: " + issues[0].String())
+	}
+	if !early {
+		if err := s.store.MkdirAll(ctx, filepath.Join(s.driver.LayoutRulesRoot(), site, "rules"), 0o755); err != nil {
+			return nil, err
+		}
+		return []fileChange{{Key: ruleKey(site, iplist.RuleFile), Path: s.rulePath(site, iplist.RuleFile), Data: conf, Managed: true}}, nil
+	}
+	if err := s.store.MkdirAll(ctx, filepath.Join(s.driver.LayoutRulesRoot(), site, crs.EarlyDir), 0o755); err != nil {
+		return nil, err
+	}
+	changes := []fileChange{{Key: earlyKey(site, iplist.EarlyFile), Path: s.earlyPath(site, iplist.EarlyFile), Data: conf}}
+	if _, err := s.store.Read(ctx, s.rulePath(site, iplist.RuleFile)); err == nil {
+		changes = append(changes, fileChange{Key: ruleKey(site, iplist.RuleFile), Path: s.rulePath(site, iplist.RuleFile), Delete: true, Managed: true})
+	}
+	return changes, nil
+}
+
+// ipListRuleCurrent reports whether site has its rule file in the right
+// place and up to date. The returned changes render a crs.conf predating
+// crs.EarlyDir again (see siteEarlyDir).
+func (s *Server) ipListRuleCurrent(ctx context.Context, site string) (current, early bool, refresh []fileChange) {
+	early, refresh = s.siteEarlyDir(ctx, site)
+	path := s.rulePath(site, iplist.RuleFile)
+	if early {
+		path = s.earlyPath(site, iplist.EarlyFile)
+	}
+	b, err := s.store.Read(ctx, path)
+	return err == nil && bytes.Equal(b, iplist.RenderRules(site, s.ipListDir(""), s.ipListDir(site))), early, refresh
+}
+
+// ipListMissingData returns the changes that create the data files of one
+// scope that do not exist yet, since Coraza does not load a rule file
+// referring to a missing one.
+func (s *Server) ipListMissingData(ctx context.Context, site string, now time.Time) ([]fileChange, error) {
+	l, err := s.loadIPLists(ctx, site)
+	if err != nil {
+		return nil, err
+	}
+	var changes []fileChange
+	for _, ch := range s.ipListFiles(site, l, now)[1:] {
+		if _, err := s.store.Read(ctx, ch.Path); err != nil {
+			changes = append(changes, ch)
+		}
+	}
+	return changes, nil
+}
+
+// ipListInstall returns the changes that give site the rule file, and the
+// data files it refers to, unless it is current already. Site writes use
+// it so that the global lists also cover sites created after them.
+func (s *Server) ipListInstall(ctx context.Context, site string) ([]fileChange, error) {
+	current, early, refresh := s.ipListRuleCurrent(ctx, site)
+	if current {
+		return nil, nil
+	}
+	if err := s.store.MkdirAll(ctx, s.ipListDir(""), 0o755); err != nil {
+		return nil, err
+	}
+	rules, err := s.ipListRuleFiles(ctx, site, early)
+	if err != nil {
+		return nil, err
+	}
+	changes := append(refresh, rules...)
+	now := time.Now()
+	for _, scope := range []string{"", site} {
+		data, err := s.ipListMissingData(ctx, scope, now)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, data...)
+	}
+	return changes, nil
+}
+
+// saveIPLists writes the lists of one scope and applies them. The rule
+// file of a site refers to the global data files too, so saving a site
+// creates missing global files, and saving the global lists installs the
+// rule file in every site that lacks it or has an outdated or misplaced
+// one.
+func (s *Server) saveIPLists(ctx context.Context, site string, l iplist.Lists, meta revision.Revision) (revision.Revision, error) {
+	now := time.Now()
+	if err := s.store.MkdirAll(ctx, s.ipListDir(""), 0o755); err != nil {
+		return revision.Revision{}, err
+	}
+	changes := s.ipListFiles(site, l, now)
+	var sites []string
+	if site != "" {
+		sites = []string{site}
+		data, err := s.ipListMissingData(ctx, "", now)
+		if err != nil {
+			return revision.Revision{}, err
+		}
+		changes = append(changes, data...)
+	} else {
+		for _, rs := range s.ruleSites(ctx) {
+			if current, _, _ := s.ipListRuleCurrent(ctx, rs); !current {
+				sites = append(sites, rs)
+			}
+		}
+	}
+	for _, rs := range sites {
+		early, refresh := s.siteEarlyDir(ctx, rs)
+		rules, err := s.ipListRuleFiles(ctx, rs, early)
+		if err != nil {
+			return revision.Revision{}, err
+		}
+		changes = append(append(changes, refresh...), rules...)
+		if rs != site {
+			data, err := s.ipListMissingData(ctx, rs, now)
+			if err != nil {
+				return revision.Revision{}, err
+			}
+			changes = append(changes, data...)
+		}
+	}
+	revs, err := s.applyChanges(ctx, changes, meta)
+	if err != nil {
+		return revision.Revision{}, err
+	}
+	return revs[0], nil
+}
+
+// ipListScope reads the optional site and the list kind from the URL.
+func ipListScope(w http.ResponseWriter, r *http.Request) (site, kind string, ok bool) {
+	site = chi.URLParam(r, "name")
+	if site != "" && !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return "", "", false
+	}
+	kind = chi.URLParam(r, "list")
+	if kind != "" && !iplist.ValidKind(kind) {
+		writeErr(w, 404, "unknown ip list, must be allow or block")
+		return "", "", false
+	}
+	return site, kind, true
+}
+
+func (s *Server) getIPLists(w http.ResponseWriter, r *http.Request) {
+	site, _, ok := ipListScope(w, r)
+	if !ok {
+		return
+	}
+	l, err := s.loadIPLists(r.Context(), site)
+	writeJSON(w, l, err)
+}
+
+func (s *Server) getIPList(w http.ResponseWriter, r *http.Request) {
+	site, kind, ok := ipListScope(w, r)
+	if !ok {
+		return
+	}
+	l, err := s.loadIPLists(r.Context(), site)
+	writeJSON(w, *l.Get(kind), err)
+}
+
+func (s *Server) addIPListEntry(w http.ResponseWriter, r *http.Request) {
+	site, kind, ok := ipListScope(w, r)
+	if !ok {
+		return
+	}
+	var e iplist.Entry
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		writeErr(w, 400, "invalid json")
+		return
+	}
+	if err := e.Validate(); err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	if e.Expired(time.Now()) {
+		writeErr(w, 400, "expires is in the past")
+		return
+	}
+	e.ID, e.Author, e.Created = iplist.NewID(), author(r), time.Now().UTC()
+
+	s.managedMu.Lock()
+	defer s.managedMu.Unlock()
+	l, err := s.loadIPLists(r.Context(), site)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	list := l.Get(kind)
+	for _, c := range *list {
+		if c.CIDR == e.CIDR {
+			writeErr(w, 409, e.CIDR+" is already on the list as "+c.ID)
+			return
+		}
+	}
+	*list = append(*list, e)
+	rev, err := s.saveIPLists(r.Context(), site, l, revision.Revision{Author: author(r), Op: "iplist-add"})
+	if err != nil {
+		writeApplyErr(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(withResults(r, map[string]any{"entry": e, "revision": rev.Number}))
+}
+
+func (s *Server) deleteIPListEntry(w http.ResponseWriter, r *http.Request) {
+	site, kind, ok := ipListScope(w, r)
+	if !ok {
+		return
+	}
+	s.managedMu.Lock()
+	defer s.managedMu.Unlock()
+	l, err := s.loadIPLists(r.Context(), site)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	list := l.Get(kind)
+	i := -1
+	for j, e := range *list {
+		if e.ID == chi.URLParam(r, "id") {
+			i = j
+		}
+	}
+	if i < 0 {
+		writeErr(w, 404, "entry not found")
+		return
+	}
+	*list = append((*list)[:i], (*list)[i+1:]...)
+	rev, err := s.saveIPLists(r.Context(), site, l, revision.Revision{Author: author(r), Op: "iplist-delete"})
+	if err != nil {
+		writeApplyErr(w, err)
+		return
+	}
+	writeJSON(w, withResults(r, map[string]any{"ok": true, "revision": rev.Number}), nil)
+}
+
+// pruneIPLists drops expired entries from the global and every site's
+// lists. The caller holds managedMu.
+func (s *Server) pruneIPLists(ctx context.Context, now time.Time) error {
+	var errs []error
+	for _, site := range append([]string{""}, s.ruleSites(ctx)...) {
+		l, err := s.loadIPLists(ctx, site)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !l.Prune(now) {
+			continue
+		}
+		if _, err := s.saveIPLists(ctx, site, l, revision.Revision{Author: "waf-admin", Op: "expire"}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
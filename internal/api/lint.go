@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Stack-Dash/waf-admin/internal/seclang"
+)
+
+type lintReq struct {
+	Content string `json:"content"`
+}
+
+type lintResp struct {
+	OK     bool            `json:"ok"`
+	Issues []seclang.Issue `json:"issues"`
+}
+
+func (s *Server) lint(w http.ResponseWriter, r *http.Request) {
+	var req lintReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, 400, "invalid body")
+		return
+	}
+	issues := seclang.Lint(req.Content)
+	if issues == nil {
+		issues = []seclang.Issue{}
+	}
+	writeJSON(w, lintResp{OK: !seclang.HasErrors(issues), Issues: issues}, nil)
+}
+
+// lintRejected lints rule content and, if it has errors, writes a 422
+// response listing all issues.
+func lintRejected(w http.ResponseWriter, content string) bool {
+	issues := seclang.Lint(content)
+	if !seclang.HasErrors(issues) {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(422)
+	_ = json.NewEncoder(w).Encode(lintResp{OK: false, Issues: issues})
+	return true
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Stack-Dash/waf-admin/internal/domain"
+	"github.com/Stack-Dash/waf-admin/internal/revision"
+)
+
+type siteMode struct {
+	Mode string `json:"mode"`
+	// Managed is false while the site has no mode file; the engine mode
+	// is then whatever the snippet and rule files set.
+	Managed bool `json:"managed"`
+}
+
+func (s *Server) getSiteMode(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	b, err := s.store.Read(r.Context(), s.rulePath(site, domain.ModeFile))
+	if err != nil {
+		writeJSON(w, siteMode{}, nil)
+		return
+	}
+	w.Header().Set("ETag", etag(b))
+	writeJSON(w, siteMode{Mode: domain.ParseMode(b), Managed: true}, nil)
+}
+
+// putSiteMode sets the site's SecRuleEngine through its mode file.
+func (s *Server) putSiteMode(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	var req siteMode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, 400, "invalid json")
+		return
+	}
+	mode, ok := domain.CanonicalMode(req.Mode)
+	if !ok {
+		writeErr(w, 400, "mode must be one of "+strings.Join(domain.EngineModes, ", "))
+		return
+	}
+	path := s.rulePath(site, domain.ModeFile)
+	if err := s.store.MkdirAll(r.Context(), filepath.Dir(path), 0o755); err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	ch := fileChange{
+		Key:     ruleKey(site, domain.ModeFile),
+		Path:    path,
+		Data:    domain.RenderMode(site, mode),
+		IfMatch: r.Header.Get("If-Match"),
+		Managed: true,
+	}
+	rev, err := s.applyChange(r.Context(), ch, revision.Revision{Author: author(r), Op: "mode"})
+	if err != nil {
+		writeApplyErr(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(ch.Data))
+	writeJSON(w, withResults(r, map[string]any{"ok": true, "mode": mode, "revision": rev.Number}), nil)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Stack-Dash/waf-admin/internal/revision"
+)
+
+// revisionTarget resolves the site or rule file addressed by r into its
+// history key and on-disk path.
+func (s *Server) revisionTarget(r *http.Request) (key, path string, ok bool) {
+	if site := chi.URLParam(r, "name"); site != "" {
+		if !siteNameRe.MatchString(site) {
+			return "", "", false
+		}
+		return siteKey(site), s.sitePath(site), true
+	}
+	site := chi.URLParam(r, "site")
+	file := chi.URLParam(r, "file")
+	if !siteNameRe.MatchString(site) || !fileNameRe.MatchString(file) {
+		return "", "", false
+	}
+	return ruleKey(site, file), s.rulePath(site, file), true
+}
+
+func revisionNumber(r *http.Request) (int, bool) {
+	n, err := strconv.Atoi(chi.URLParam(r, "rev"))
+	return n, err == nil && n > 0
+}
+
+func (s *Server) listRevisions(w http.ResponseWriter, r *http.Request) {
+	key, _, ok := s.revisionTarget(r)
+	if !ok {
+		writeErr(w, 400, "invalid name")
+		return
+	}
+	revs, err := s.revs.List(key)
+	writeJSON(w, revs, err)
+}
+
+func (s *Server) getRevision(w http.ResponseWriter, r *http.Request) {
+	key, _, ok := s.revisionTarget(r)
+	n, okRev := revisionNumber(r)
+	if !ok || !okRev {
+		writeErr(w, 400, "invalid name or revision")
+		return
+	}
+	rev, err := s.revs.Get(key, n)
+	if errors.Is(err, revision.ErrNotFound) {
+		writeErr(w, 404, "revision not found")
+		return
+	}
+	writeJSON(w, rev, err)
+}
+
+// rollbackRevision restores the content of a past revision (or removes
+// the file if that revision was a delete) through the regular
+// validate/reload path, recording the result as a new revision.
+func (s *Server) rollbackRevision(w http.ResponseWriter, r *http.Request) {
+	key, path, ok := s.revisionTarget(r)
+	n, okRev := revisionNumber(r)
+	if !ok || !okRev {
+		writeErr(w, 400, "invalid name or revision")
+		return
+	}
+	target, err := s.revs.Get(key, n)
+	if errors.Is(err, revision.ErrNotFound) {
+		writeErr(w, 404, "revision not found")
+		return
+	}
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	ch := fileChange{Key: key, Path: path, Data: []byte(target.Content), Delete: target.Deleted, IfMatch: r.Header.Get("If-Match")}
+	rev, err := s.applyChange(r.Context(), ch, revision.Revision{Author: author(r), Op: "rollback", RollbackOf: n})
+	if err != nil {
+		writeApplyErr(w, err)
+		return
+	}
+	writeJSON(w, withResults(r, map[string]any{"ok": true, "revision": rev.Number}), nil)
+}
@@ -23,20 +23,76 @@ func (s *Server) routes() http.Handler {
 	})
 
 	p := chi.NewRouter()
-	p.Use(auth.Bearer(s.cfg.Auth.Token))
+	p.Use(auth.Bearer(s.cfg.Auth.Token), collectResults)
 
 	p.Get("/v1/sites", s.listSites)
 	p.Get("/v1/sites/{name}", s.getSite)
 	p.Put("/v1/sites/{name}", s.putSite)
 	p.Delete("/v1/sites/{name}", s.deleteSite)
+	p.Get("/v1/sites/{name}/revisions", s.listRevisions)
+	p.Get("/v1/sites/{name}/revisions/{rev}", s.getRevision)
+	p.Post("/v1/sites/{name}/revisions/{rev}/rollback", s.rollbackRevision)
+	p.Get("/v1/sites/{name}/crs", s.getSiteCRS)
+	p.Put("/v1/sites/{name}/crs", s.putSiteCRS)
+	p.Post("/v1/sites/{name}/crs/upgrade", s.upgradeSiteCRS)
+	p.Get("/v1/sites/{name}/mode", s.getSiteMode)
+	p.Put("/v1/sites/{name}/mode", s.putSiteMode)
+	p.Get("/v1/sites/{name}/exclusions", s.listExclusions)
+	p.Post("/v1/sites/{name}/exclusions", s.createExclusion)
+	p.Get("/v1/sites/{name}/exclusions/{id}", s.getExclusion)
+	p.Put("/v1/sites/{name}/exclusions/{id}", s.updateExclusion)
+	p.Delete("/v1/sites/{name}/exclusions/{id}", s.deleteExclusion)
+	p.Post("/v1/sites/{name}/simulate", s.simulate)
+	p.Get("/v1/sites/{name}/tests", s.listTests)
+	p.Post("/v1/sites/{name}/tests", s.createTest)
+	p.Post("/v1/sites/{name}/tests/run", s.runSiteTests)
+	p.Delete("/v1/sites/{name}/tests/{id}", s.deleteTest)
+	p.Get("/v1/sites/{name}/geo-policy", s.getGeoPolicy)
+	p.Put("/v1/sites/{name}/geo-policy", s.putGeoPolicy)
+	p.Delete("/v1/sites/{name}/geo-policy", s.deleteGeoPolicy)
+	p.Get("/v1/sites/{name}/ip-lists", s.getIPLists)
+	p.Get("/v1/sites/{name}/ip-lists/{list}", s.getIPList)
+	p.Post("/v1/sites/{name}/ip-lists/{list}", s.addIPListEntry)
+	p.Delete("/v1/sites/{name}/ip-lists/{list}/{id}", s.deleteIPListEntry)
 
 	p.Get("/v1/rules/{site}", s.listRules)
 	p.Get("/v1/rules/{site}/{file}", s.getRule)
 	p.Put("/v1/rules/{site}/{file}", s.putRule)
 	p.Delete("/v1/rules/{site}/{file}", s.deleteRule)
+	p.Post("/v1/rules/{site}/{file}/enable", s.enableRule)
+	p.Post("/v1/rules/{site}/{file}/disable", s.disableRule)
+	p.Get("/v1/rules/{site}/{file}/revisions", s.listRevisions)
+	p.Get("/v1/rules/{site}/{file}/revisions/{rev}", s.getRevision)
+	p.Post("/v1/rules/{site}/{file}/revisions/{rev}/rollback", s.rollbackRevision)
 
+	p.Get("/v1/rule-ids", s.ruleIDs)
+
+	p.Get("/v1/crs/versions", s.listCRSVersions)
+	p.Post("/v1/crs/versions", s.installCRS)
+	p.Delete("/v1/crs/versions/{version}", s.deleteCRSVersion)
+
+	p.Get("/v1/ip-lists", s.getIPLists)
+	p.Get("/v1/ip-lists/{list}", s.getIPList)
+	p.Post("/v1/ip-lists/{list}", s.addIPListEntry)
+	p.Delete("/v1/ip-lists/{list}/{id}", s.deleteIPListEntry)
+
+	p.Get("/v1/changesets", s.listChangesets)
+	p.Post("/v1/changesets", s.createChangeset)
+	p.Get("/v1/changesets/{id}", s.getChangeset)
+	p.Delete("/v1/changesets/{id}", s.deleteChangeset)
+	p.Post("/v1/changesets/{id}/changes", s.stageChange)
+	p.Delete("/v1/changesets/{id}/changes/{index}", s.unstageChange)
+	p.Get("/v1/changesets/{id}/preview", s.previewChangeset)
+	p.Post("/v1/changesets/{id}/commit", s.commitChangeset)
+
+	p.Get("/v1/events", s.listEvents)
+	p.Get("/v1/events/{id}", s.getEvent)
+	p.Post("/v1/events/{id}/suggest-exclusion", s.suggestExclusion)
+
+	p.Post("/v1/lint", s.lint)
 	p.Post("/v1/validate", s.validate)
 	p.Post("/v1/apply", s.apply)
+	p.Get("/v1/instances", s.instances)
 	// p.Post("/v1/backup", s.backupNow)
 
 	r.Mount("/", p)
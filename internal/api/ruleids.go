@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Stack-Dash/waf-admin/internal/domain"
+)
+
+// ruleIDs reports where rule IDs are defined, either for a single ?id= or
+// for every rule, optionally limited to one ?site=. Rules of a pinned CRS
+// release are listed under "crs-<version>/<file>".
+func (s *Server) ruleIDs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	site := q.Get("site")
+	if site != "" && !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	ix, _, err := s.ruleIndex(r.Context(), nil)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	if idStr := q.Get("id"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			writeErr(w, 400, "invalid id")
+			return
+		}
+		locs := []domain.RuleLocation{}
+		for _, l := range ix.Lookup(id) {
+			if site == "" || l.Site == site {
+				locs = append(locs, l)
+			}
+		}
+		writeJSON(w, locs, nil)
+		return
+	}
+	locs := ix.All(site)
+	if locs == nil {
+		locs = []domain.RuleLocation{}
+	}
+	writeJSON(w, locs, nil)
+}
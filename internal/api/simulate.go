@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Stack-Dash/waf-admin/internal/crs"
+	"github.com/Stack-Dash/waf-admin/internal/wafsim"
+)
+
+type simulateRequest struct {
+	// Request is a raw HTTP/1.1 request, e.g. "GET /?q=1 HTTP/1.1\nHost: example.com\n\n".
+	Request  string `json:"request"`
+	ClientIP string `json:"clientIp"`
+	// Files adds or replaces rule files of the site for this simulation
+	// only, so drafts can be tried before they are saved.
+	Files map[string]string `json:"files"`
+}
+
+// siteRuleset returns the sources Caddy would load for site: the pinned
+// CRS release, then the enabled rule files in name order, with drafts
+// taking the place of stored files of the same name.
+func (s *Server) siteRuleset(ctx context.Context, site string, drafts map[string]string) []wafsim.Source {
+	sources := []wafsim.Source{{Content: wafsim.Defaults}}
+	if _, err := s.store.Read(ctx, s.crsPath(site, crs.ConfFile)); err == nil {
+		sources = append(sources, wafsim.Source{Path: s.crsPath(site, crs.ConfFile)})
+	}
+	var names []string
+	if ents, err := s.store.List(ctx, s.rulePath(site, "")); err == nil {
+		for _, e := range ents {
+			if !e.IsDir() && fileNameRe.MatchString(e.Name()) && strings.HasSuffix(e.Name(), ".conf") {
+				if _, ok := drafts[e.Name()]; !ok {
+					names = append(names, e.Name())
+				}
+			}
+		}
+	}
+	for name := range drafts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if content, ok := drafts[name]; ok {
+			sources = append(sources, wafsim.Source{Content: content})
+		} else {
+			sources = append(sources, wafsim.Source{Path: s.rulePath(site, name)})
+		}
+	}
+	return sources
+}
+
+// simulate runs a request through the site's ruleset in an embedded
+// Coraza instead of Caddy. Nothing is written or reloaded.
+func (s *Server) simulate(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	var req simulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, 400, "invalid json")
+		return
+	}
+	if req.ClientIP == "" {
+		req.ClientIP = "127.0.0.1"
+	}
+	for name := range req.Files {
+		if !fileNameRe.MatchString(name) || !strings.HasSuffix(name, ".conf") {
+			writeErr(w, 400, "invalid rule file name "+name)
+			return
+		}
+	}
+	hreq, err := wafsim.ParseRequest(req.Request)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	waf, err := wafsim.New(s.siteRuleset(r.Context(), site, req.Files))
+	if err != nil {
+		writeErr(w, 400, "ruleset does not load: "+err.Error())
+		return
+	}
+	res, err := waf.Run(hreq, req.ClientIP)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	writeJSON(w, res, nil)
+}
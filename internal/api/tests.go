@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Stack-Dash/waf-admin/internal/execproto"
+	"github.com/Stack-Dash/waf-admin/internal/reload"
+	"github.com/Stack-Dash/waf-admin/internal/storage"
+	"github.com/Stack-Dash/waf-admin/internal/wafsim"
+)
+
+func (s *Server) testsPath(site string) string {
+	return filepath.Join(s.driver.LayoutRulesRoot(), site, wafsim.TestsFile)
+}
+
+func (s *Server) loadTests(ctx context.Context, site string) ([]wafsim.Case, error) {
+	b, err := s.store.Read(ctx, s.testsPath(site))
+	if err != nil {
+		return []wafsim.Case{}, nil
+	}
+	return wafsim.LoadCases(b)
+}
+
+// saveTests stores the suite. Tests do not change what Caddy runs, so
+// nothing is validated or reloaded.
+func (s *Server) saveTests(ctx context.Context, site string, list []wafsim.Case, msg string) error {
+	if err := s.store.MkdirAll(ctx, filepath.Dir(s.testsPath(site)), 0o755); err != nil {
+		return err
+	}
+	return s.store.WriteAtomic(storage.WithMessage(ctx, msg), s.testsPath(site), wafsim.MarshalCases(list), 0o644)
+}
+
+// runTests runs the site's suite against its current rule files, with
+// drafts in place of stored files of the same name.
+func (s *Server) runTests(ctx context.Context, site string, cases []wafsim.Case, drafts map[string]string) ([]wafsim.CaseResult, error) {
+	waf, err := wafsim.New(s.siteRuleset(ctx, site, drafts))
+	if err != nil {
+		return nil, fmt.Errorf("ruleset does not load: %w", err)
+	}
+	results := make([]wafsim.CaseResult, 0, len(cases))
+	for _, c := range cases {
+		results = append(results, waf.RunCase(c))
+	}
+	return results, nil
+}
+
+func (s *Server) listTests(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	list, err := s.loadTests(r.Context(), site)
+	writeJSON(w, list, err)
+}
+
+// createTest stores a test case and returns how the current rules fare
+// against it.
+func (s *Server) createTest(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	var c wafsim.Case
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeErr(w, 400, "invalid json")
+		return
+	}
+	if err := c.Validate(); err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	c.ID, c.Author, c.Created = wafsim.NewID(), author(r), time.Now().UTC()
+
+	s.managedMu.Lock()
+	defer s.managedMu.Unlock()
+	list, err := s.loadTests(r.Context(), site)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	if err := s.saveTests(r.Context(), site, append(list, c), "add test "+c.ID+" to tests/"+site+" by "+author(r)); err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	resp := map[string]any{"test": c}
+	if results, err := s.runTests(r.Context(), site, []wafsim.Case{c}, nil); err != nil {
+		resp["error"] = err.Error()
+	} else {
+		resp["result"] = results[0]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) deleteTest(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	s.managedMu.Lock()
+	defer s.managedMu.Unlock()
+	list, err := s.loadTests(r.Context(), site)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	id := chi.URLParam(r, "id")
+	for i, c := range list {
+		if c.ID == id {
+			list = append(list[:i], list[i+1:]...)
+			if err := s.saveTests(r.Context(), site, list, "delete test "+id+" from tests/"+site+" by "+author(r)); err != nil {
+				writeErr(w, 500, err.Error())
+				return
+			}
+			writeJSON(w, map[string]any{"ok": true}, nil)
+			return
+		}
+	}
+	writeErr(w, 404, "test not found")
+}
+
+// runSiteTests runs the whole suite. An optional body {"files": {...}}
+// tries drafts like simulate does.
+func (s *Server) runSiteTests(w http.ResponseWriter, r *http.Request) {
+	site := chi.URLParam(r, "name")
+	if !siteNameRe.MatchString(site) {
+		writeErr(w, 400, "invalid site name")
+		return
+	}
+	var req struct {
+		Files map[string]string `json:"files"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, 400, "invalid json")
+			return
+		}
+	}
+	for name := range req.Files {
+		if !fileNameRe.MatchString(name) || !strings.HasSuffix(name, ".conf") {
+			writeErr(w, 400, "invalid rule file name "+name)
+			return
+		}
+	}
+	cases, err := s.loadTests(r.Context(), site)
+	if err != nil {
+		writeErr(w, 500, err.Error())
+		return
+	}
+	results, err := s.runTests(r.Context(), site, cases, req.Files)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	failed := 0
+	for _, res := range results {
+		if !res.Passed {
+			failed++
+		}
+	}
+	writeJSON(w, map[string]any{"passed": len(results) - failed, "failed": failed, "results": results}, nil)
+}
+
+// withChangedSites tells applyNow and the reloader which sites and files
+// changes touch (see reload.WithSites and execproto.WithPaths); global
+// state touches all sites.
+func withChangedSites(ctx context.Context, changes []fileChange) context.Context {
+	var paths []string
+	for _, ch := range changes {
+		if ch.RenameFrom != "" {
+			paths = append(paths, ch.RenameFrom)
+		}
+		paths = append(paths, ch.Path)
+	}
+	ctx = execproto.WithPaths(ctx, paths)
+
+	seen := map[string]bool{}
+	var sites []string
+	for _, ch := range changes {
+		// keys are <kind>/<site>[/<file>], except for global state such as
+		// the global IP lists
+		kind, rest, _ := strings.Cut(ch.Key, "/")
+		site, _, _ := strings.Cut(rest, "/")
+		if kind == ipListKey("") || site == "" {
+			return reload.WithSites(ctx, nil)
+		}
+		if !seen[site] {
+			seen[site] = true
+			sites = append(sites, site)
+		}
+	}
+	return reload.WithSites(ctx, sites)
+}
+
+// checkTests runs the regression suites of the changed sites (all sites
+// when unknown) against the files as written and fails on the first site
+// with a failing case.
+func (s *Server) checkTests(ctx context.Context) error {
+	sites := reload.Sites(ctx)
+	if sites == nil {
+		sites = s.ruleSites(ctx)
+	}
+	for _, site := range sites {
+		cases, err := s.loadTests(ctx, site)
+		if err != nil {
+			return fmt.Errorf("regression tests of %s: %w", site, err)
+		}
+		if len(cases) == 0 {
+			continue
+		}
+		results, err := s.runTests(ctx, site, cases, nil)
+		if err != nil {
+			return fmt.Errorf("regression tests of %s: %w", site, err)
+		}
+		var failures []string
+		for _, res := range results {
+			if !res.Passed {
+				failures = append(failures, fmt.Sprintf("%q (%s): %s", res.Name, res.ID, res.Failure))
+			}
+		}
+		if len(failures) > 0 {
+			return fmt.Errorf("regression tests of %s failed: %s", site, strings.Join(failures, "; "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package auditlog
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is one audited transaction.
+type Event struct {
+	ID           string    `json:"id"`
+	Time         time.Time `json:"time"`
+	Site         string    `json:"site"`
+	Instance     string    `json:"instance,omitempty"`
+	ClientIP     string    `json:"clientIp"`
+	Host         string    `json:"host,omitempty"`
+	Method       string    `json:"method"`
+	URI          string    `json:"uri"`
+	Status       int       `json:"status,omitempty"`
+	Action       string    `json:"action"`
+	AnomalyScore int       `json:"anomalyScore"`
+	RuleIDs      []int     `json:"ruleIds"`
+	Matches      []Match   `json:"matches"`
+}
+
+const (
+	ActionBlocked  = "blocked"
+	ActionDetected = "detected"
+)
+
+// UnknownSite is the site of events that cannot be attributed to a
+// configured site. The Host header is client input, so it is only kept in
+// Event.Host.
+const UnknownSite = "unknown"
+
+// Match is a rule that matched during the transaction.
+type Match struct {
+	RuleID   int      `json:"ruleId"`
+	Msg      string   `json:"msg,omitempty"`
+	Data     string   `json:"data,omitempty"`
+	Severity string   `json:"severity,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	File     string   `json:"file,omitempty"`
+}
+
+// corazaLog is the subset of Coraza's JSON audit log format (SecAuditLogFormat
+// JSON, part K for messages) that events are built from.
+type corazaLog struct {
+	Transaction struct {
+		Timestamp     string `json:"timestamp"`
+		UnixTimestamp int64  `json:"unix_timestamp"`
+		ID            string `json:"id"`
+		ClientIP      string `json:"client_ip"`
+		Request       *struct {
+			Method  string              `json:"method"`
+			URI     string              `json:"uri"`
+			Headers map[string][]string `json:"headers"`
+		} `json:"request"`
+		Response *struct {
+			Status int `json:"status"`
+		} `json:"response"`
+		IsInterrupted bool `json:"is_interrupted"`
+	} `json:"transaction"`
+	Messages []struct {
+		Data *struct {
+			File     string          `json:"file"`
+			ID       int             `json:"id"`
+			Msg      string          `json:"msg"`
+			Data     string          `json:"data"`
+			Severity json.RawMessage `json:"severity"`
+			Tags     []string        `json:"tags"`
+		} `json:"data"`
+	} `json:"messages"`
+}
+
+var (
+	totalScoreRe = regexp.MustCompile(`Total Score: (\d+)`)
+	blockingRe   = regexp.MustCompile(`blocking=(\d+)`)
+)
+
+// severities in Coraza's numeric order
+var severities = []string{"emergency", "alert", "critical", "error", "warning", "notice", "info", "debug"}
+
+// Parser turns audit log lines into events. Site is used for every event
+// when set; otherwise it is taken from a matched rule file below
+// RulesRoot/<site>/, and finally from the Host header, mapped through
+// SiteForHost; it is UnknownSite if that does not know the host either.
+// Instance names the Caddy instance writing the log.
+type Parser struct {
+	Site        string
+	Instance    string
+	RulesRoot   string
+	SiteForHost func(host string) string
+}
+
+func (p Parser) Parse(line []byte) (Event, error) {
+	var l corazaLog
+	if err := json.Unmarshal(line, &l); err != nil {
+		return Event{}, err
+	}
+	tx := l.Transaction
+	if tx.ID == "" {
+		return Event{}, errors.New("not a coraza audit log entry")
+	}
+	ev := Event{
+		ID:       tx.ID,
+		Instance: p.Instance,
+		ClientIP: tx.ClientIP,
+		Action:   ActionDetected,
+		RuleIDs:  []int{},
+		Matches:  []Match{},
+	}
+	switch {
+	case tx.UnixTimestamp > 1e15:
+		ev.Time = time.Unix(0, tx.UnixTimestamp).UTC()
+	case tx.UnixTimestamp > 0:
+		ev.Time = time.Unix(tx.UnixTimestamp, 0).UTC()
+	default:
+		ev.Time, _ = time.ParseInLocation("2006/01/02 15:04:05", tx.Timestamp, time.Local)
+		ev.Time = ev.Time.UTC()
+	}
+	if tx.IsInterrupted {
+		ev.Action = ActionBlocked
+	}
+	if tx.Request != nil {
+		ev.Method, ev.URI = tx.Request.Method, tx.Request.URI
+		for k, v := range tx.Request.Headers {
+			if strings.EqualFold(k, "host") && len(v) > 0 {
+				ev.Host = v[0]
+			}
+		}
+	}
+	if tx.Response != nil {
+		ev.Status = tx.Response.Status
+	}
+	for _, m := range l.Messages {
+		if m.Data == nil || m.Data.ID == 0 {
+			continue
+		}
+		ev.Matches = append(ev.Matches, Match{
+			RuleID:   m.Data.ID,
+			Msg:      m.Data.Msg,
+			Data:     m.Data.Data,
+			Severity: severity(m.Data.Severity),
+			Tags:     m.Data.Tags,
+			File:     m.Data.File,
+		})
+		ev.RuleIDs = append(ev.RuleIDs, m.Data.ID)
+		if s := totalScoreRe.FindStringSubmatch(m.Data.Msg); s != nil {
+			ev.AnomalyScore, _ = strconv.Atoi(s[1])
+		} else if s := blockingRe.FindStringSubmatch(m.Data.Msg); s != nil && ev.AnomalyScore == 0 {
+			ev.AnomalyScore, _ = strconv.Atoi(s[1])
+		}
+	}
+	ev.Site = p.site(ev)
+	return ev, nil
+}
+
+func (p Parser) site(ev Event) string {
+	if p.Site != "" {
+		return p.Site
+	}
+	if p.RulesRoot != "" {
+		root := filepath.Clean(p.RulesRoot) + string(filepath.Separator)
+		for _, m := range ev.Matches {
+			if rest, ok := strings.CutPrefix(m.File, root); ok {
+				if site, _, ok := strings.Cut(rest, string(filepath.Separator)); ok {
+					return site
+				}
+			}
+		}
+	}
+	host := ev.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if p.SiteForHost != nil {
+		if site := p.SiteForHost(host); site != "" {
+			return site
+		}
+	}
+	return UnknownSite
+}
+
+// severity accepts Coraza's numeric severities as well as names.
+func severity(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var n int
+	if err := json.Unmarshal(raw, &n); err == nil {
+		if n >= 0 && n < len(severities) {
+			return severities[n]
+		}
+		return ""
+	}
+	var s string
+	_ = json.Unmarshal(raw, &s)
+	return strings.ToLower(s)
+}
@@ -0,0 +1,79 @@
+package auditlog
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics counts ingested events for Prometheus. Only events read after
+// startup are counted, as usual for counters.
+type Metrics struct {
+	transactions *prometheus.CounterVec
+	ruleHits     *prometheus.CounterVec
+	anomaly      *prometheus.HistogramVec
+	countries    *prometheus.CounterVec
+
+	country func(ip string) string
+	known   func(site string) bool
+}
+
+// NewMetrics registers the WAF metrics with reg. country maps a client
+// address to an ISO country code; nil or an empty result counts the
+// transaction as "unknown". known reports whether a site is configured;
+// events of other sites are counted under UnknownSite, so that clients
+// cannot add label values. nil counts every site as it is.
+func NewMetrics(reg prometheus.Registerer, country func(ip string) string, known func(site string) bool) *Metrics {
+	m := &Metrics{
+		transactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "waf",
+			Name:      "transactions_total",
+			Help:      "Audited WAF transactions by site and action (blocked, or detected and allowed through).",
+		}, []string{"site", "action"}),
+		ruleHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "waf",
+			Name:      "rule_hits_total",
+			Help:      "Rule matches by site and rule ID.",
+		}, []string{"site", "rule_id"}),
+		anomaly: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "waf",
+			Name:      "anomaly_score",
+			Help:      "Inbound anomaly score of audited transactions.",
+			Buckets:   []float64{0, 3, 5, 10, 15, 20, 25, 50, 100},
+		}, []string{"site"}),
+		countries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "waf",
+			Name:      "country_transactions_total",
+			Help:      "Audited WAF transactions by site, client country and action.",
+		}, []string{"site", "country", "action"}),
+		country: country,
+		known:   known,
+	}
+	reg.MustRegister(m.transactions, m.ruleHits, m.anomaly, m.countries)
+	return m
+}
+
+// Observe counts one event.
+func (m *Metrics) Observe(ev Event) {
+	site := ev.Site
+	if m.known != nil && !m.known(site) {
+		site = UnknownSite
+	}
+	m.transactions.WithLabelValues(site, ev.Action).Inc()
+	m.anomaly.WithLabelValues(site).Observe(float64(ev.AnomalyScore))
+	seen := map[int]bool{}
+	for _, id := range ev.RuleIDs {
+		if !seen[id] {
+			seen[id] = true
+			m.ruleHits.WithLabelValues(site, strconv.Itoa(id)).Inc()
+		}
+	}
+	cc := ""
+	if m.country != nil {
+		cc = m.country(ev.ClientIP)
+	}
+	if cc == "" {
+		cc = "unknown"
+	}
+	m.countries.WithLabelValues(site, cc, ev.Action).Inc()
+}
@@ -0,0 +1,349 @@
+package auditlog
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dayLayout = "2006-01-02"
+
+// Store keeps events as JSON lines in one file per UTC day under Dir and
+// drops days older than Retention.
+type Store struct {
+	Dir       string
+	Retention time.Duration
+
+	mu sync.Mutex
+}
+
+func NewStore(dir string, retention time.Duration) *Store {
+	return &Store{Dir: dir, Retention: retention}
+}
+
+func (s *Store) dayPath(day string) string { return filepath.Join(s.Dir, day+".jsonl") }
+
+// Append stores events. Events older than the retention period are
+// dropped.
+func (s *Store) Append(events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	cutoff := s.cutoff()
+	byDay := map[string]*bytes.Buffer{}
+	for _, ev := range events {
+		if ev.Time.Before(cutoff) {
+			continue
+		}
+		day := ev.Time.UTC().Format(dayLayout)
+		if byDay[day] == nil {
+			byDay[day] = &bytes.Buffer{}
+		}
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		byDay[day].Write(append(b, '\n'))
+	}
+	for day, buf := range byDay {
+		f, err := os.OpenFile(s.dayPath(day), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(buf.Bytes()); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) cutoff() time.Time {
+	if s.Retention <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-s.Retention)
+}
+
+// Prune deletes day files that lie entirely outside the retention period.
+func (s *Store) Prune() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Retention <= 0 {
+		return nil
+	}
+	days, err := s.days()
+	if err != nil {
+		return err
+	}
+	cutoff := s.cutoff().UTC().Format(dayLayout)
+	var errs []error
+	for _, d := range days {
+		if d < cutoff {
+			if err := os.Remove(s.dayPath(d)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// days returns the stored days, oldest first.
+func (s *Store) days() ([]string, error) {
+	ents, err := os.ReadDir(s.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range ents {
+		day, ok := strings.CutSuffix(e.Name(), ".jsonl")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(dayLayout, day); err == nil {
+			out = append(out, day)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// Filter selects events. Zero fields match everything. IP is an address
+// or a CIDR prefix.
+type Filter struct {
+	Site     string
+	Instance string
+	RuleID   int
+	IP       string
+	Action   string
+	From     time.Time
+	To       time.Time
+}
+
+func (f Filter) match(ev Event, ipNet *net.IPNet) bool {
+	switch {
+	case f.Site != "" && ev.Site != f.Site:
+		return false
+	case f.Instance != "" && ev.Instance != f.Instance:
+		return false
+	case f.Action != "" && ev.Action != f.Action:
+		return false
+	case !f.From.IsZero() && ev.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && !ev.Time.Before(f.To):
+		return false
+	}
+	if f.IP != "" {
+		if ipNet != nil {
+			if ip := net.ParseIP(ev.ClientIP); ip == nil || !ipNet.Contains(ip) {
+				return false
+			}
+		} else if ev.ClientIP != f.IP {
+			return false
+		}
+	}
+	if f.RuleID != 0 {
+		for _, id := range ev.RuleIDs {
+			if id == f.RuleID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Query returns up to limit matching events, newest first, skipping the
+// first offset matches. more reports whether further matches exist. Only
+// the newest matches still needed are kept while a day file is read.
+func (s *Store) Query(f Filter, offset, limit int) (events []Event, more bool, err error) {
+	var ipNet *net.IPNet
+	if strings.Contains(f.IP, "/") {
+		if _, ipNet, err = net.ParseCIDR(f.IP); err != nil {
+			return nil, false, err
+		}
+	}
+	events = []Event{}
+	skipped := 0
+	err = s.eachDay(f, func(day string) (bool, error) {
+		// One more than what is left tells whether there are more matches.
+		want := offset - skipped + limit - len(events) + 1
+		top := &newest{}
+		seq := 0
+		err := s.eachLine(day, func(line []byte) bool {
+			var ev Event
+			if json.Unmarshal(line, &ev) != nil || !f.match(ev, ipNet) {
+				return true
+			}
+			heap.Push(top, seqEvent{ev, seq})
+			seq++
+			if top.Len() > want {
+				heap.Pop(top)
+			}
+			return true
+		})
+		if err != nil {
+			return false, err
+		}
+		matches := make([]seqEvent, top.Len())
+		for i := len(matches) - 1; i >= 0; i-- {
+			matches[i] = heap.Pop(top).(seqEvent)
+		}
+		for _, m := range matches {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			if len(events) == limit {
+				more = true
+				return false, nil
+			}
+			events = append(events, m.Event)
+		}
+		return true, nil
+	})
+	return events, more, err
+}
+
+// Count returns how many events match f, and how many of those were
+// blocked.
+func (s *Store) Count(f Filter) (total, blocked int, err error) {
+	var ipNet *net.IPNet
+	if strings.Contains(f.IP, "/") {
+		if _, ipNet, err = net.ParseCIDR(f.IP); err != nil {
+			return 0, 0, err
+		}
+	}
+	err = s.eachDay(f, func(day string) (bool, error) {
+		return true, s.eachLine(day, func(line []byte) bool {
+			var ev Event
+			if json.Unmarshal(line, &ev) == nil && f.match(ev, ipNet) {
+				total++
+				if ev.Action == ActionBlocked {
+					blocked++
+				}
+			}
+			return true
+		})
+	})
+	return total, blocked, err
+}
+
+// Get returns the event with the given transaction ID. Only lines that
+// contain the ID are decoded.
+func (s *Store) Get(id string) (Event, bool, error) {
+	var (
+		found Event
+		ok    bool
+	)
+	quoted, err := json.Marshal(id)
+	if err != nil {
+		return found, false, err
+	}
+	err = s.eachDay(Filter{}, func(day string) (bool, error) {
+		err := s.eachLine(day, func(line []byte) bool {
+			if !bytes.Contains(line, quoted) {
+				return true
+			}
+			var ev Event
+			if json.Unmarshal(line, &ev) == nil && ev.ID == id {
+				found, ok = ev, true
+				return false
+			}
+			return true
+		})
+		return !ok, err
+	})
+	return found, ok, err
+}
+
+// eachDay calls fn with the stored days newest first, restricted to the
+// days covered by f's time range, until fn returns false or an error.
+func (s *Store) eachDay(f Filter, fn func(day string) (bool, error)) error {
+	s.mu.Lock()
+	days, err := s.days()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for i := len(days) - 1; i >= 0; i-- {
+		d := days[i]
+		if !f.From.IsZero() && d < f.From.UTC().Format(dayLayout) {
+			break
+		}
+		if !f.To.IsZero() && d > f.To.UTC().Format(dayLayout) {
+			continue
+		}
+		if ok, err := fn(d); err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachLine streams the lines of one day file in file order until fn
+// returns false. Lines that do not parse, such as a partially written last
+// line, are left to fn to skip.
+func (s *Store) eachLine(day string, fn func(line []byte) bool) error {
+	f, err := os.Open(s.dayPath(day))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), maxLine)
+	for sc.Scan() {
+		if !fn(sc.Bytes()) {
+			return nil
+		}
+	}
+	return sc.Err()
+}
+
+// seqEvent is an event with its position among a day's matches, which
+// breaks ties between events with the same time in favour of the later
+// line.
+type seqEvent struct {
+	Event
+	seq int
+}
+
+// newest is a min-heap of events by time, used to keep the newest
+// matches of a day.
+type newest []seqEvent
+
+func (h newest) Len() int { return len(h) }
+func (h newest) Less(i, j int) bool {
+	if !h[i].Time.Equal(h[j].Time) {
+		return h[i].Time.Before(h[j].Time)
+	}
+	return h[i].seq < h[j].seq
+}
+func (h newest) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *newest) Push(x any)   { *h = append(*h, x.(seqEvent)) }
+func (h *newest) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
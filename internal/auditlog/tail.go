@@ -0,0 +1,202 @@
+package auditlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxLine bounds a single audit log entry; longer lines are skipped.
+const maxLine = 16 << 20
+
+// Source is one audit log file to follow.
+type Source struct {
+	Path   string
+	Parser Parser
+}
+
+// Ingester follows audit logs and appends their events to a Store. Read
+// offsets are kept in <Store.Dir>/offsets.json so a restart resumes where
+// it stopped. OnEvent, when set, is called for every stored event.
+type Ingester struct {
+	OnEvent func(Event)
+
+	store    *Store
+	sources  []Source
+	interval time.Duration
+
+	mu      sync.Mutex
+	offsets map[string]int64
+}
+
+func NewIngester(st *Store, sources []Source) *Ingester {
+	return &Ingester{store: st, sources: sources, interval: time.Second, offsets: map[string]int64{}}
+}
+
+func (in *Ingester) offsetsPath() string { return filepath.Join(in.store.Dir, "offsets.json") }
+
+func (in *Ingester) loadOffsets() {
+	b, err := os.ReadFile(in.offsetsPath())
+	if err != nil {
+		return
+	}
+	_ = json.Unmarshal(b, &in.offsets)
+}
+
+func (in *Ingester) saveOffsets() {
+	in.mu.Lock()
+	b, _ := json.Marshal(in.offsets)
+	in.mu.Unlock()
+	if err := os.MkdirAll(in.store.Dir, 0o755); err != nil {
+		log.Error().Err(err).Msg("save audit log offsets")
+		return
+	}
+	tmp := in.offsetsPath() + ".tmp"
+	err := os.WriteFile(tmp, b, 0o644)
+	if err == nil {
+		err = os.Rename(tmp, in.offsetsPath())
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("save audit log offsets")
+	}
+}
+
+// Run follows all sources until ctx is cancelled.
+func (in *Ingester) Run(ctx context.Context) {
+	in.loadOffsets()
+	var wg sync.WaitGroup
+	for _, src := range in.sources {
+		wg.Add(1)
+		go func(src Source) {
+			defer wg.Done()
+			in.follow(ctx, src)
+		}(src)
+	}
+	wg.Wait()
+	in.saveOffsets()
+}
+
+// follow polls src for new complete lines. The file is reopened from the
+// start when it is replaced (rotation) or shrinks (truncation).
+func (in *Ingester) follow(ctx context.Context, src Source) {
+	var (
+		f    *os.File
+		fi   os.FileInfo
+		rd   *bufio.Reader
+		off  int64
+		part []byte
+	)
+	in.mu.Lock()
+	off = in.offsets[src.Path]
+	in.mu.Unlock()
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	t := time.NewTicker(in.interval)
+	defer t.Stop()
+	for {
+		cur, err := os.Stat(src.Path)
+		switch {
+		case err != nil:
+			// not there yet or being rotated
+		case f == nil || !os.SameFile(fi, cur) || cur.Size() < off:
+			if f != nil {
+				// pick up what was written before the rotation
+				in.drain(rd, &part, src)
+				f.Close()
+				f, rd, off = nil, nil, 0
+			}
+			nf, err := os.Open(src.Path)
+			if err != nil {
+				break
+			}
+			f = nf
+			if cur.Size() < off {
+				off = 0
+			}
+			if _, err := f.Seek(off, io.SeekStart); err != nil {
+				off = 0
+			}
+			fi, rd, part = cur, bufio.NewReaderSize(f, 64*1024), nil
+		}
+		if rd != nil {
+			n := in.drain(rd, &part, src)
+			if n > 0 {
+				off += n
+				in.mu.Lock()
+				in.offsets[src.Path] = off
+				in.mu.Unlock()
+				in.saveOffsets()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// drain reads all complete lines available from rd, stores their events
+// and returns the number of bytes consumed. An incomplete last line is
+// kept in part for the next call.
+func (in *Ingester) drain(rd *bufio.Reader, part *[]byte, src Source) int64 {
+	var (
+		consumed int64
+		events   []Event
+	)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] != '\n' {
+			*part = append(*part, line...)
+			if len(*part) > maxLine {
+				log.Warn().Str("path", src.Path).Msg("audit log line too long, skipped")
+				consumed += int64(len(*part))
+				*part = nil
+			}
+			break
+		}
+		if len(line) > 0 {
+			full := append(*part, line...)
+			*part = nil
+			consumed += int64(len(full))
+			full = bytes.TrimSpace(full)
+			if len(full) > 0 {
+				ev, perr := src.Parser.Parse(full)
+				if perr != nil {
+					log.Warn().Err(perr).Str("path", src.Path).Msg("skip unparsable audit log entry")
+				} else {
+					events = append(events, ev)
+				}
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Error().Err(err).Str("path", src.Path).Msg("read audit log")
+			}
+			break
+		}
+	}
+	if len(events) > 0 {
+		if err := in.store.Append(events); err != nil {
+			log.Error().Err(err).Msg("store audit events")
+		} else if in.OnEvent != nil {
+			for _, ev := range events {
+				in.OnEvent(ev)
+			}
+		}
+	}
+	return consumed
+}
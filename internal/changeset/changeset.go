@@ -0,0 +1,197 @@
+package changeset
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("changeset not found")
+	ErrNotOpen  = errors.New("changeset is not open")
+)
+
+const (
+	StatusOpen       = "open"
+	StatusCommitting = "committing"
+	StatusCommitted  = "committed"
+)
+
+// Change is one staged site or rule mutation. File is only set for rules;
+// Content is ignored for deletes. IfMatch optionally pins the ETag the
+// file must still have when the changeset is committed.
+type Change struct {
+	Kind    string `json:"kind"`
+	Site    string `json:"site"`
+	File    string `json:"file,omitempty"`
+	Op      string `json:"op"`
+	Content string `json:"content,omitempty"`
+	IfMatch string `json:"ifMatch,omitempty"`
+}
+
+func (c Change) sameTarget(o Change) bool {
+	return c.Kind == o.Kind && c.Site == o.Site && c.File == o.File
+}
+
+type Changeset struct {
+	ID          string    `json:"id"`
+	Description string    `json:"description,omitempty"`
+	Author      string    `json:"author"`
+	Created     time.Time `json:"created"`
+	Status      string    `json:"status"`
+	Changes     []Change  `json:"changes"`
+	Error       string    `json:"error,omitempty"`
+	Revisions   []int     `json:"revisions,omitempty"`
+}
+
+// Store holds changesets in memory; staged work does not survive a
+// restart.
+type Store struct {
+	mu   sync.Mutex
+	sets map[string]*Changeset
+}
+
+func NewStore() *Store { return &Store{sets: map[string]*Changeset{}} }
+
+func (s *Store) Create(description, author string) *Changeset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs := &Changeset{
+		ID:          newID(),
+		Description: description,
+		Author:      author,
+		Created:     time.Now().UTC(),
+		Status:      StatusOpen,
+		Changes:     []Change{},
+	}
+	s.sets[cs.ID] = cs
+	return cs.copy()
+}
+
+func (s *Store) Get(id string) (*Changeset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, ok := s.sets[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cs.copy(), nil
+}
+
+// List returns all changesets, newest first.
+func (s *Store) List() []*Changeset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*Changeset, 0, len(s.sets))
+	for _, cs := range s.sets {
+		out = append(out, cs.copy())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+	return out
+}
+
+// Stage adds c to an open changeset, replacing any change already staged
+// for the same site or rule file.
+func (s *Store) Stage(id string, c Change) (*Changeset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, ok := s.sets[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if cs.Status != StatusOpen {
+		return nil, ErrNotOpen
+	}
+	for i := range cs.Changes {
+		if cs.Changes[i].sameTarget(c) {
+			cs.Changes[i] = c
+			return cs.copy(), nil
+		}
+	}
+	cs.Changes = append(cs.Changes, c)
+	return cs.copy(), nil
+}
+
+// Unstage removes the change at index i.
+func (s *Store) Unstage(id string, i int) (*Changeset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, ok := s.sets[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if cs.Status != StatusOpen {
+		return nil, ErrNotOpen
+	}
+	if i < 0 || i >= len(cs.Changes) {
+		return nil, ErrNotFound
+	}
+	cs.Changes = append(cs.Changes[:i], cs.Changes[i+1:]...)
+	return cs.copy(), nil
+}
+
+// Begin marks an open changeset as committing so it cannot be modified or
+// committed twice, and returns its contents.
+func (s *Store) Begin(id string) (*Changeset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, ok := s.sets[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if cs.Status != StatusOpen {
+		return nil, ErrNotOpen
+	}
+	cs.Status = StatusCommitting
+	return cs.copy(), nil
+}
+
+// Finish records the outcome of a commit started with Begin. A failed
+// commit reopens the changeset so it can be fixed and retried.
+func (s *Store) Finish(id string, revisions []int, err error) *Changeset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, ok := s.sets[id]
+	if !ok {
+		return nil
+	}
+	if err != nil {
+		cs.Status = StatusOpen
+		cs.Error = err.Error()
+	} else {
+		cs.Status = StatusCommitted
+		cs.Error = ""
+		cs.Revisions = revisions
+	}
+	return cs.copy()
+}
+
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, ok := s.sets[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if cs.Status == StatusCommitting {
+		return ErrNotOpen
+	}
+	delete(s.sets, id)
+	return nil
+}
+
+func (cs *Changeset) copy() *Changeset {
+	c := *cs
+	c.Changes = append([]Change{}, cs.Changes...)
+	c.Revisions = append([]int(nil), cs.Revisions...)
+	return &c
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
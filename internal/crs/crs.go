@@ -0,0 +1,294 @@
+package crs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ConfFile and SetupFile live in <RulesRoot>/<site>/ and are owned by
+	// waf-admin. Site snippets include ConfFile before their own rules.
+	ConfFile  = "crs.conf"
+	SetupFile = "crs-setup.conf"
+	// EarlyDir in <RulesRoot>/<site>/ holds waf-admin's managed rule files
+	// whose phase 1 rules have to run before the CRS's. ConfFile includes
+	// it between SetupFile and the CRS rules.
+	EarlyDir = "early"
+
+	pinHeader = "# waf-admin crs version: "
+)
+
+var (
+	ErrNotFound = errors.New("crs version not installed")
+	ErrExists   = errors.New("crs version already installed")
+
+	versionRe = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z._-]*$`)
+	// release tarballs unpack into e.g. coreruleset-4.7.0/
+	tarballDirRe = regexp.MustCompile(`^coreruleset-v?([0-9A-Za-z._-]+)$`)
+)
+
+type Version struct {
+	Version   string    `json:"version"`
+	Path      string    `json:"path"`
+	RuleFiles int       `json:"ruleFiles"`
+	Installed time.Time `json:"installed"`
+}
+
+// Manager keeps installed CRS releases under <Dir>/<version>. Dir must be
+// visible to Caddy under the same path.
+type Manager struct {
+	Dir string
+
+	mu    sync.Mutex
+	files map[string]map[string][]byte
+}
+
+func NewManager(dir string) *Manager {
+	return &Manager{Dir: dir, files: map[string]map[string][]byte{}}
+}
+
+func ValidVersion(v string) bool { return versionRe.MatchString(v) }
+
+func (m *Manager) Path(version string) string { return filepath.Join(m.Dir, version) }
+
+// RuleFiles returns the rule files of an installed version in load order.
+func (m *Manager) RuleFiles(version string) ([]string, error) {
+	if !ValidVersion(version) {
+		return nil, ErrNotFound
+	}
+	files, err := filepath.Glob(filepath.Join(m.Path(version), "rules", "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrNotFound
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Files returns the rule files of an installed version keyed by file name.
+// Releases are never modified once installed, so the content is cached.
+func (m *Manager) Files(version string) (map[string][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f, ok := m.files[version]; ok {
+		return f, nil
+	}
+	paths, err := m.RuleFiles(version)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte, len(paths))
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		out[filepath.Base(p)] = b
+	}
+	m.files[version] = out
+	return out, nil
+}
+
+// SetupExample returns the crs-setup.conf.example shipped with version.
+func (m *Manager) SetupExample(version string) ([]byte, error) {
+	b, err := os.ReadFile(filepath.Join(m.Path(version), "crs-setup.conf.example"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+func (m *Manager) Versions() ([]Version, error) {
+	ents, err := os.ReadDir(m.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Version{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := []Version{}
+	for _, e := range ents {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		v, err := m.Get(e.Name())
+		if err != nil {
+			continue
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (m *Manager) Get(version string) (Version, error) {
+	files, err := m.RuleFiles(version)
+	if err != nil {
+		return Version{}, err
+	}
+	v := Version{Version: version, Path: m.Path(version), RuleFiles: len(files)}
+	if fi, err := os.Stat(m.Path(version)); err == nil {
+		v.Installed = fi.ModTime().UTC()
+	}
+	return v, nil
+}
+
+func (m *Manager) Remove(version string) error {
+	if _, err := m.Get(version); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.files, version)
+	m.mu.Unlock()
+	return os.RemoveAll(m.Path(version))
+}
+
+// Install unpacks a CRS release tarball (.tar.gz) into <Dir>/<version>.
+// When version is empty it is taken from the tarball's top-level
+// coreruleset-<version>/ directory. The release must contain rules/*.conf
+// and crs-setup.conf.example.
+func (m *Manager) Install(r io.Reader, version string) (Version, error) {
+	if version != "" && !ValidVersion(version) {
+		return Version{}, fmt.Errorf("invalid version %q", version)
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return Version{}, err
+	}
+	tmp, err := os.MkdirTemp(m.Dir, ".install-")
+	if err != nil {
+		return Version{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	top, err := extract(r, tmp)
+	if err != nil {
+		return Version{}, err
+	}
+	root := tmp
+	if top != "" {
+		root = filepath.Join(tmp, top)
+		if version == "" {
+			if m := tarballDirRe.FindStringSubmatch(top); m != nil {
+				version = m[1]
+			}
+		}
+	}
+	if version == "" {
+		return Version{}, errors.New("version not given and not derivable from tarball")
+	}
+	if !ValidVersion(version) {
+		return Version{}, fmt.Errorf("invalid version %q", version)
+	}
+	if rules, _ := filepath.Glob(filepath.Join(root, "rules", "*.conf")); len(rules) == 0 {
+		return Version{}, errors.New("tarball has no rules/*.conf")
+	}
+	if _, err := os.Stat(filepath.Join(root, "crs-setup.conf.example")); err != nil {
+		return Version{}, errors.New("tarball has no crs-setup.conf.example")
+	}
+	if _, err := os.Stat(m.Path(version)); err == nil {
+		return Version{}, ErrExists
+	}
+	if err := os.Rename(root, m.Path(version)); err != nil {
+		return Version{}, err
+	}
+	return m.Get(version)
+}
+
+// extract unpacks regular files and directories of a gzipped tarball
+// into dir. It returns the single top-level directory, if all entries
+// share one.
+func extract(r io.Reader, dir string) (string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return "", fmt.Errorf("open tarball: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	top := ""
+	single := true
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("read tarball: %w", err)
+		}
+		name := filepath.Clean(filepath.FromSlash(h.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("tarball entry %q escapes target directory", h.Name)
+		}
+		first, _, _ := strings.Cut(filepath.ToSlash(name), "/")
+		if top == "" {
+			top = first
+		} else if first != top {
+			single = false
+		}
+
+		dest := filepath.Join(dir, name)
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, 0o755); err != nil {
+				return "", err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+				return "", err
+			}
+			f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+			if err != nil {
+				return "", err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return "", err
+			}
+			if err := f.Close(); err != nil {
+				return "", err
+			}
+		}
+	}
+	if !single {
+		top = ""
+	}
+	if top != "" {
+		if fi, err := os.Stat(filepath.Join(dir, top)); err != nil || !fi.IsDir() {
+			top = ""
+		}
+	}
+	return top, nil
+}
+
+// RenderConf renders a site's crs.conf, loading its crs-setup.conf, the
+// files in its EarlyDir and then the rules of version.
+func (m *Manager) RenderConf(siteDir, version string) []byte {
+	var b strings.Builder
+	b.WriteString(pinHeader + version + "\n")
+	b.WriteString("# Managed by waf-admin, do not edit. Use /v1/sites/{site}/crs instead.\n")
+	fmt.Fprintf(&b, "Include %s\n", filepath.Join(siteDir, SetupFile))
+	fmt.Fprintf(&b, "Include %s\n", filepath.Join(siteDir, EarlyDir, "*.conf"))
+	fmt.Fprintf(&b, "Include %s\n", filepath.Join(m.Path(version), "rules", "*.conf"))
+	return []byte(b.String())
+}
+
+// PinnedVersion returns the version recorded in a rendered crs.conf.
+func PinnedVersion(conf []byte) string {
+	line, _, _ := strings.Cut(string(conf), "\n")
+	v, ok := strings.CutPrefix(line, pinHeader)
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Stack-Dash/waf-admin/internal/seclang"
+)
+
+// ModeFile is the waf-admin owned rule file in <RulesRoot>/<site>/rules
+// that sets the site's SecRuleEngine. It sorts after the site's other rule
+// files so its setting wins over theirs.
+const ModeFile = "zz-waf-admin-mode.conf"
+
+// EngineModes are the values SecRuleEngine accepts.
+var EngineModes = []string{"On", "DetectionOnly", "Off"}
+
+// CanonicalMode returns mode spelled as SecRuleEngine expects it,
+// matching case-insensitively.
+func CanonicalMode(mode string) (string, bool) {
+	for _, m := range EngineModes {
+		if strings.EqualFold(m, mode) {
+			return m, true
+		}
+	}
+	return "", false
+}
+
+func RenderMode(site, mode string) []byte {
+	return []byte(fmt.Sprintf("# Managed by waf-admin, do not edit. Use /v1/sites/%s/mode instead.\nSecRuleEngine %s\n", site, mode))
+}
+
+// ParseMode returns the last SecRuleEngine value set in content.
+func ParseMode(content []byte) string {
+	dirs, _ := seclang.Parse(string(content))
+	mode := ""
+	for _, d := range dirs {
+		if strings.EqualFold(d.Name, "SecRuleEngine") && len(d.Args) == 1 {
+			if m, ok := CanonicalMode(d.Args[0].Value); ok {
+				mode = m
+			}
+		}
+	}
+	return mode
+}
@@ -7,4 +7,6 @@ type SiteInfo struct {
 	RuleFiles   []string `json:"ruleFiles,omitempty"`
 	HasSnippet  bool     `json:"hasSnippet"`
 	HasRulesDir bool     `json:"hasRulesDir"`
+	CRSVersion  string   `json:"crsVersion,omitempty"`
+	Mode        string   `json:"mode,omitempty"`
 }
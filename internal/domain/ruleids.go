@@ -0,0 +1,213 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Stack-Dash/waf-admin/internal/render"
+	"github.com/Stack-Dash/waf-admin/internal/seclang"
+	"github.com/Stack-Dash/waf-admin/internal/storage"
+)
+
+// RuleLocation is where a rule ID is defined.
+type RuleLocation struct {
+	ID      int    `json:"id"`
+	Site    string `json:"site"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Enabled bool   `json:"enabled"`
+}
+
+// RuleIndex maps rule IDs to the site rule files that define them.
+type RuleIndex struct {
+	// files holds the locations per "<site>/<file>".
+	files map[string][]RuleLocation
+}
+
+// BuildRuleIndex parses every rule file under <RulesRoot>/<site>/rules.
+// Files ending in .disabled are indexed but marked as not enabled.
+func BuildRuleIndex(ctx context.Context, dr render.Driver, st storage.Storage) (*RuleIndex, error) {
+	ix := &RuleIndex{files: map[string][]RuleLocation{}}
+	sites, err := st.List(ctx, dr.LayoutRulesRoot())
+	if err != nil {
+		return ix, nil
+	}
+	for _, s := range sites {
+		if !s.IsDir() || strings.HasPrefix(s.Name(), ".") {
+			continue
+		}
+		dir := filepath.Join(dr.LayoutRulesRoot(), s.Name(), "rules")
+		files, err := st.List(ctx, dir)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if f.IsDir() || !isRuleFile(f.Name()) {
+				continue
+			}
+			b, err := st.Read(ctx, filepath.Join(dir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			ix.Set(s.Name(), f.Name(), b)
+		}
+	}
+	return ix, nil
+}
+
+func isRuleFile(name string) bool {
+	return strings.HasSuffix(name, ".conf") || strings.HasSuffix(name, ".conf.disabled")
+}
+
+// Set replaces the indexed IDs of a site's rule file with those defined
+// in content.
+func (ix *RuleIndex) Set(site, file string, content []byte) {
+	var locs []RuleLocation
+	for _, r := range RuleIDs(string(content)) {
+		r.Site, r.File, r.Enabled = site, file, strings.HasSuffix(file, ".conf")
+		locs = append(locs, r)
+	}
+	ix.files[site+"/"+file] = locs
+}
+
+// Remove drops a site's rule file from the index.
+func (ix *RuleIndex) Remove(site, file string) { delete(ix.files, site+"/"+file) }
+
+// SetCRS indexes the rule files of the CRS release version as part of
+// site's effective ruleset, replacing any release indexed before. It
+// returns the file names the rules are indexed under.
+func (ix *RuleIndex) SetCRS(site, version string, files map[string][]byte) []string {
+	ix.RemoveCRS(site)
+	names := make([]string, 0, len(files))
+	for name, b := range files {
+		file := crsFilePrefix + version + "/" + name
+		ix.Set(site, file, b)
+		names = append(names, file)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RemoveCRS drops the CRS release indexed for site.
+func (ix *RuleIndex) RemoveCRS(site string) {
+	for k := range ix.files {
+		// site rule file names cannot contain a slash, CRS ones always do
+		if file, ok := strings.CutPrefix(k, site+"/"); ok && strings.Contains(file, "/") {
+			delete(ix.files, k)
+		}
+	}
+}
+
+const crsFilePrefix = "crs-"
+
+// Lookup returns every location of id, sorted by site and file.
+func (ix *RuleIndex) Lookup(id int) []RuleLocation {
+	var out []RuleLocation
+	for _, locs := range ix.files {
+		for _, l := range locs {
+			if l.ID == id {
+				out = append(out, l)
+			}
+		}
+	}
+	sortLocations(out)
+	return out
+}
+
+// All returns every indexed location, optionally limited to one site,
+// sorted by ID.
+func (ix *RuleIndex) All(site string) []RuleLocation {
+	var out []RuleLocation
+	for _, locs := range ix.files {
+		for _, l := range locs {
+			if site == "" || l.Site == site {
+				out = append(out, l)
+			}
+		}
+	}
+	sortLocations(out)
+	return out
+}
+
+// Conflicts returns the IDs defined more than once among the enabled rule
+// files of site that involve the given file, keyed by ID.
+func (ix *RuleIndex) Conflicts(site, file string) map[int][]RuleLocation {
+	byID := map[int][]RuleLocation{}
+	for _, l := range ix.All(site) {
+		if l.Enabled {
+			byID[l.ID] = append(byID[l.ID], l)
+		}
+	}
+	out := map[int][]RuleLocation{}
+	for id, locs := range byID {
+		if len(locs) < 2 {
+			continue
+		}
+		for _, l := range locs {
+			if l.File == file {
+				out[id] = locs
+				break
+			}
+		}
+	}
+	return out
+}
+
+// ConflictError describes rule IDs that would be defined twice in a
+// site's effective ruleset.
+type ConflictError struct {
+	Site      string
+	Conflicts map[int][]RuleLocation
+}
+
+func (e *ConflictError) Error() string {
+	ids := make([]int, 0, len(e.Conflicts))
+	for id := range e.Conflicts {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		var where []string
+		for _, l := range e.Conflicts[id] {
+			where = append(where, fmt.Sprintf("%s:%d", l.File, l.Line))
+		}
+		parts = append(parts, fmt.Sprintf("%d (%s)", id, strings.Join(where, ", ")))
+	}
+	return fmt.Sprintf("duplicate rule ids in site %s: %s", e.Site, strings.Join(parts, "; "))
+}
+
+// RuleIDs returns the IDs defined by SecRule and SecAction directives in
+// content, with the line they appear on. Site and File are left empty.
+func RuleIDs(content string) []RuleLocation {
+	dirs, _ := seclang.Parse(content)
+	var out []RuleLocation
+	for _, d := range dirs {
+		if d.Rule == nil || d.Rule.Chained {
+			continue
+		}
+		if id, ok := d.Rule.ID(); ok {
+			out = append(out, RuleLocation{ID: id, Line: d.Pos.Line})
+		}
+	}
+	return out
+}
+
+func sortLocations(locs []RuleLocation) {
+	sort.Slice(locs, func(i, j int) bool {
+		a, b := locs[i], locs[j]
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		if a.Site != b.Site {
+			return a.Site < b.Site
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/Stack-Dash/waf-admin/internal/crs"
 	"github.com/Stack-Dash/waf-admin/internal/render"
 	"github.com/Stack-Dash/waf-admin/internal/storage"
 )
@@ -16,10 +17,10 @@ func ListSites(ctx context.Context, dr render.Driver, st storage.Storage) ([]Sit
 	}
 	var out []SiteInfo
 	for _, e := range ents {
-		if e.IsDir() || !strings.HasSuffix(e.Name(), ".caddy") {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), dr.SiteExtension()) {
 			continue
 		}
-		name := strings.TrimSuffix(e.Name(), ".caddy")
+		name := strings.TrimSuffix(e.Name(), dr.SiteExtension())
 		info := SiteInfo{
 			Name:        name,
 			SnippetPath: filepath.Join(dr.LayoutSites(), e.Name()),
@@ -29,7 +30,36 @@ func ListSites(ctx context.Context, dr render.Driver, st storage.Storage) ([]Sit
 		if _, err := st.List(ctx, info.RulesPath); err == nil {
 			info.HasRulesDir = true
 		}
+		if b, err := st.Read(ctx, filepath.Join(dr.LayoutRulesRoot(), name, crs.ConfFile)); err == nil {
+			info.CRSVersion = crs.PinnedVersion(b)
+		}
+		if b, err := st.Read(ctx, filepath.Join(info.RulesPath, ModeFile)); err == nil {
+			info.Mode = ParseMode(b)
+		}
 		out = append(out, info)
 	}
 	return out, nil
 }
+
+// SiteHosts maps the host names a site file serves to the site name.
+func SiteHosts(ctx context.Context, dr render.Driver, st storage.Storage) (map[string]string, error) {
+	ents, err := st.List(ctx, dr.LayoutSites())
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	for _, e := range ents {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), dr.SiteExtension()) {
+			continue
+		}
+		b, err := st.Read(ctx, filepath.Join(dr.LayoutSites(), e.Name()))
+		if err != nil {
+			continue
+		}
+		site := strings.TrimSuffix(e.Name(), dr.SiteExtension())
+		for _, h := range dr.SiteHosts(b) {
+			out[h] = site
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,190 @@
+package exclusion
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// StateFile holds a site's exclusions as JSON in <RulesRoot>/<site>/.
+	StateFile = "exclusions.json"
+	// RuleFile is the rendered rule file in <RulesRoot>/<site>/rules/.
+	// The zz- prefix makes it load after the site's own rule files, so
+	// SecRuleRemove*/SecRuleUpdateTarget* see every rule they refer to.
+	// Other managed zz-waf-admin- files may sort after it, which is one
+	// reason exclusions cannot target waf-admin's own rule IDs.
+	RuleFile = "zz-waf-admin-exclusions.conf"
+	// EarlyFile, in <RulesRoot>/<site>/early/ (crs.EarlyDir), holds the
+	// conditional exclusions of sites with a pinned CRS, whose crs.conf
+	// loads it ahead of the CRS rules. Rules that run at configure time
+	// cannot remove rules that are loaded later, so those stay in RuleFile.
+	EarlyFile = "waf-admin-exclusions.conf"
+
+	// IDBase is the first rule ID used for conditional exclusions. The
+	// range up to IDBase+IDRange-1 is reserved for them.
+	IDBase  = 99000
+	IDRange = 500
+)
+
+var (
+	tagRe    = regexp.MustCompile(`^[A-Za-z0-9._/:-]+$`)
+	targetRe = regexp.MustCompile(`^[A-Z_]+(?::[^\s"'|,;\\]+)?$`)
+	pathRe   = regexp.MustCompile(`^/[^\s"'\\]*$`)
+	methodRe = regexp.MustCompile(`^[A-Z]+$`)
+)
+
+// Exclusion turns off a rule (by ID or tag) entirely or for one target
+// variable, optionally only for requests matching Path and Method.
+type Exclusion struct {
+	ID      string     `json:"id"`
+	RuleID  int        `json:"ruleId,omitempty"`
+	Tag     string     `json:"tag,omitempty"`
+	Target  string     `json:"target,omitempty"`
+	Path    string     `json:"path,omitempty"`
+	Method  string     `json:"method,omitempty"`
+	Reason  string     `json:"reason"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Author  string     `json:"author"`
+	Created time.Time  `json:"created"`
+}
+
+// Validate checks the fields a client supplies.
+func (e *Exclusion) Validate() error {
+	switch {
+	case (e.RuleID > 0) == (e.Tag != ""):
+		return errors.New("exactly one of ruleId and tag is required")
+	case e.RuleID < 0:
+		return errors.New("invalid ruleId")
+	case e.Tag != "" && !tagRe.MatchString(e.Tag):
+		return errors.New("invalid tag")
+	case e.Target != "" && !targetRe.MatchString(e.Target):
+		return errors.New("invalid target, expected e.g. ARGS:password or REQUEST_COOKIES")
+	case e.Path != "" && !pathRe.MatchString(e.Path):
+		return errors.New("invalid path, must start with / and contain no quotes or spaces")
+	case e.Method != "" && !methodRe.MatchString(e.Method):
+		return errors.New("invalid method")
+	case strings.TrimSpace(e.Reason) == "":
+		return errors.New("reason is required")
+	}
+	return nil
+}
+
+// Conditional reports whether the exclusion only applies to some requests
+// and therefore needs a runtime rule.
+func (e *Exclusion) Conditional() bool { return e.Path != "" || e.Method != "" }
+
+func (e *Exclusion) Expired(now time.Time) bool {
+	return e.Expires != nil && !e.Expires.After(now)
+}
+
+func NewID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Load parses a state file; missing state is an empty list.
+func Load(b []byte) ([]Exclusion, error) {
+	if len(b) == 0 {
+		return []Exclusion{}, nil
+	}
+	var out []Exclusion
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", StateFile, err)
+	}
+	return out, nil
+}
+
+func Marshal(list []Exclusion) []byte {
+	b, _ := json.MarshalIndent(list, "", "  ")
+	return append(b, '\n')
+}
+
+// Render produces the managed rule files for site. Expired exclusions are
+// left out. Unconditional exclusions become configure-time directives in
+// late, for RuleFile. Conditional ones become phase 1 rules with ctl
+// actions, numbered from IDBase in list order. With split they go to early,
+// for EarlyFile, so that they run before the CRS's phase 1 rules; without
+// it they are part of late and early is nil.
+func Render(site string, list []Exclusion, now time.Time, split bool) (early, late []byte, err error) {
+	header := fmt.Sprintf("# Managed by waf-admin, do not edit. Use /v1/sites/%s/exclusions instead.\n", site)
+	var lb, eb strings.Builder
+	lb.WriteString(header)
+	cb := &lb
+	if split {
+		eb.WriteString(header)
+		cb = &eb
+	}
+	n := 0
+	for _, e := range list {
+		if e.Expired(now) {
+			continue
+		}
+		if !e.Conditional() {
+			lb.WriteString("\n" + comment(e) + directive(e) + "\n")
+			continue
+		}
+		if n >= IDRange {
+			return nil, nil, fmt.Errorf("more than %d conditional exclusions", IDRange)
+		}
+		id := IDBase + n
+		n++
+		cb.WriteString("\n" + comment(e))
+		head := fmt.Sprintf(`"id:%d,phase:1,pass,nolog,t:none`, id)
+		ctl := "ctl:" + ctlAction(e)
+		switch {
+		case e.Path != "" && e.Method != "":
+			fmt.Fprintf(cb, "SecRule REQUEST_FILENAME \"@beginsWith %s\" \\\n    %s,chain\"\n", e.Path, head)
+			fmt.Fprintf(cb, "    SecRule REQUEST_METHOD \"@streq %s\" \"t:none,%s\"\n", e.Method, ctl)
+		case e.Path != "":
+			fmt.Fprintf(cb, "SecRule REQUEST_FILENAME \"@beginsWith %s\" \\\n    %s,%s\"\n", e.Path, head, ctl)
+		default:
+			fmt.Fprintf(cb, "SecRule REQUEST_METHOD \"@streq %s\" \\\n    %s,%s\"\n", e.Method, head, ctl)
+		}
+	}
+	if split {
+		early = []byte(eb.String())
+	}
+	return early, []byte(lb.String()), nil
+}
+
+func comment(e Exclusion) string {
+	c := fmt.Sprintf("# %s: %s (by %s", e.ID, e.Reason, e.Author)
+	if e.Expires != nil {
+		c += ", expires " + e.Expires.UTC().Format(time.RFC3339)
+	}
+	// a newline in free text would end the comment
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(c) + ")\n"
+}
+
+func directive(e Exclusion) string {
+	switch {
+	case e.RuleID > 0 && e.Target == "":
+		return fmt.Sprintf("SecRuleRemoveById %d", e.RuleID)
+	case e.RuleID > 0:
+		return fmt.Sprintf("SecRuleUpdateTargetById %d \"!%s\"", e.RuleID, e.Target)
+	case e.Target == "":
+		return fmt.Sprintf("SecRuleRemoveByTag \"%s\"", e.Tag)
+	default:
+		return fmt.Sprintf("SecRuleUpdateTargetByTag \"%s\" \"!%s\"", e.Tag, e.Target)
+	}
+}
+
+func ctlAction(e Exclusion) string {
+	switch {
+	case e.RuleID > 0 && e.Target == "":
+		return fmt.Sprintf("ruleRemoveById=%d", e.RuleID)
+	case e.RuleID > 0:
+		return fmt.Sprintf("ruleRemoveTargetById=%d;%s", e.RuleID, e.Target)
+	case e.Target == "":
+		return "ruleRemoveByTag=" + e.Tag
+	default:
+		return fmt.Sprintf("ruleRemoveTargetByTag=%s;%s", e.Tag, e.Target)
+	}
+}
@@ -0,0 +1,79 @@
+package exclusion
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Stack-Dash/waf-admin/internal/auditlog"
+)
+
+// foundWithinRe extracts the matched variable from Coraza's logdata,
+// e.g. "Matched Data: <script> found within ARGS:x: <script>alert(1)".
+var foundWithinRe = regexp.MustCompile(`found within ([A-Z_]+(?::[^\s]*?)?): `)
+
+// Suggest proposes the narrowest exclusions that would have let ev
+// through: one per matched rule and variable, scoped to the request path.
+// Anomaly evaluation and correlation rules are skipped because they only
+// add up the scores of the others.
+func Suggest(ev auditlog.Event) []Exclusion {
+	path := suggestPath(ev.URI)
+	seen := map[string]bool{}
+	var out []Exclusion
+	for _, m := range ev.Matches {
+		if scoringRule(m) {
+			continue
+		}
+		e := Exclusion{RuleID: m.RuleID, Path: path}
+		if t := foundWithinRe.FindStringSubmatch(m.Data); t != nil {
+			e.Target = t[1]
+			if !targetRe.MatchString(e.Target) {
+				// keys with quotes or separators cannot be written as a
+				// target, fall back to the whole collection
+				e.Target, _, _ = strings.Cut(e.Target, ":")
+			}
+		}
+		key := fmt.Sprintf("%d %s", e.RuleID, e.Target)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		e.Reason = fmt.Sprintf("false positive in event %s: %s", ev.ID, m.Msg)
+		out = append(out, e)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].RuleID < out[j].RuleID })
+	return out
+}
+
+func scoringRule(m auditlog.Match) bool {
+	for _, t := range m.Tags {
+		if t == "anomaly-evaluation" || t == "reporting" {
+			return true
+		}
+	}
+	switch m.RuleID / 1000 {
+	case 949, 959, 980:
+		return true
+	}
+	return false
+}
+
+// suggestPath is the decoded request path, cut before anything an
+// exclusion path cannot contain. An unusable path scopes to "/".
+func suggestPath(uri string) string {
+	p := uri
+	if u, err := url.ParseRequestURI(uri); err == nil {
+		p = u.Path
+	} else if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+	if i := strings.IndexAny(p, " \t\r\n\"'\\"); i >= 0 {
+		p = p[:i]
+	}
+	if !pathRe.MatchString(p) {
+		return "/"
+	}
+	return p
+}
@@ -0,0 +1,158 @@
+// Package execproto is the protocol between waf-admin and an external
+// executable acting as render driver and reloader. waf-admin starts the
+// executable once per operation, writes a Request as JSON to its stdin and
+// reads a Response as JSON from its stdout; stderr is only used in error
+// messages.
+package execproto
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Version is sent with every request.
+const Version = 1
+
+// Operations.
+const (
+	// Validate checks the files on disk; Request.Paths lists the changed
+	// ones and Request.Sites their sites, both empty when everything may
+	// have changed.
+	Validate = "validate"
+	// Reload makes the proxy load the validated files.
+	Reload = "reload"
+	// Stop shuts the proxy down, to be restarted by its supervisor.
+	Stop = "stop"
+	// Hosts returns the hosts the site file in Request.Content serves.
+	Hosts = "hosts"
+)
+
+// Layout is where waf-admin keeps the files.
+type Layout struct {
+	SitesDir      string `json:"sitesDir"`
+	RulesRoot     string `json:"rulesRoot"`
+	SiteExtension string `json:"siteExtension"`
+}
+
+type Request struct {
+	Version   int      `json:"version"`
+	Operation string   `json:"operation"`
+	Paths     []string `json:"paths,omitempty"`
+	Sites     []string `json:"sites,omitempty"`
+	Layout    Layout   `json:"layout"`
+	Content   string   `json:"content,omitempty"`
+}
+
+// Issue is one problem reported by the executable. File and Line are
+// optional.
+type Issue struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string {
+	switch {
+	case i.File != "" && i.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", i.File, i.Line, i.Message)
+	case i.File != "":
+		return i.File + ": " + i.Message
+	}
+	return i.Message
+}
+
+type Response struct {
+	OK     bool     `json:"ok"`
+	Errors []Issue  `json:"errors,omitempty"`
+	Hosts  []string `json:"hosts,omitempty"`
+}
+
+// Error is returned by Run when the executable answered with ok false.
+type Error struct {
+	Operation string
+	Issues    []Issue
+}
+
+func (e *Error) Error() string {
+	if len(e.Issues) == 0 {
+		return e.Operation + " failed"
+	}
+	msgs := make([]string, len(e.Issues))
+	for i, is := range e.Issues {
+		msgs[i] = is.String()
+	}
+	return e.Operation + " failed: " + strings.Join(msgs, "; ")
+}
+
+// Command runs an executable speaking the protocol.
+type Command struct {
+	Args []string
+	// Timeout bounds every run, 30s when zero.
+	Timeout time.Duration
+	Layout  Layout
+}
+
+// Run sends a request for op and returns the response. A response with ok
+// false is an *Error; so is a non-zero exit with a valid response. Other
+// failures, such as a timeout or output that is not a response, are
+// reported with the executable's stderr.
+func (c *Command) Run(ctx context.Context, op string, req Request) (*Response, error) {
+	if len(c.Args) == 0 {
+		return nil, errors.New("no command configured")
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req.Version, req.Operation, req.Layout = Version, op, c.Layout
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Args[0], c.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(in), &stdout, &stderr
+	// children of a killed executable may keep its output open
+	cmd.WaitDelay = time.Second
+	runErr := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s: %s timed out after %s", op, c.Args[0], timeout)
+	}
+	var resp Response
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		if runErr != nil {
+			err = runErr
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s: %w: %s", op, c.Args[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s: %s: %w", op, c.Args[0], err)
+	}
+	if !resp.OK || runErr != nil {
+		return &resp, &Error{Operation: op, Issues: resp.Errors}
+	}
+	return &resp, nil
+}
+
+type pathsKey struct{}
+
+// WithPaths records on ctx which files an operation is for. nil means
+// all of them.
+func WithPaths(ctx context.Context, paths []string) context.Context {
+	return context.WithValue(ctx, pathsKey{}, paths)
+}
+
+// Paths returns the paths recorded with WithPaths.
+func Paths(ctx context.Context) []string {
+	paths, _ := ctx.Value(pathsKey{}).([]string)
+	return paths
+}
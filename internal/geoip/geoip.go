@@ -0,0 +1,93 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/rs/zerolog/log"
+)
+
+// recheck is how often the database file is checked for replacement.
+const recheck = time.Minute
+
+// DB looks up countries in a GeoLite2/GeoIP2 country or city database. The
+// file is opened lazily and reopened when it is replaced, so a missing
+// database or a daily update needs no restart.
+type DB struct {
+	Path string
+
+	mu      sync.Mutex
+	r       *maxminddb.Reader
+	mod     time.Time
+	checked time.Time
+}
+
+func Open(path string) *DB { return &DB{Path: path} }
+
+// Check reports whether path is a readable MaxMind database.
+func Check(path string) error {
+	r, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	return r.Close()
+}
+
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Country returns the ISO 3166 country code for ip, or "" when the address
+// or the database does not tell.
+func (db *DB) Country(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.refresh()
+	if db.r == nil {
+		return ""
+	}
+	var rec record
+	if err := db.r.Lookup(addr, &rec); err != nil {
+		return ""
+	}
+	if rec.Country.ISOCode != "" {
+		return rec.Country.ISOCode
+	}
+	return rec.RegisteredCountry.ISOCode
+}
+
+// refresh opens the database, or reopens it when the file has changed.
+// Callers hold mu, which also keeps the old reader from being closed
+// during a lookup.
+func (db *DB) refresh() {
+	now := time.Now()
+	if now.Sub(db.checked) < recheck {
+		return
+	}
+	db.checked = now
+	fi, err := os.Stat(db.Path)
+	if err != nil || (db.r != nil && fi.ModTime().Equal(db.mod)) {
+		return
+	}
+	r, err := maxminddb.Open(db.Path)
+	if err != nil {
+		log.Warn().Err(err).Str("path", db.Path).Msg("open geoip database")
+		return
+	}
+	if db.r != nil {
+		db.r.Close()
+	}
+	db.r, db.mod = r, fi.ModTime()
+}
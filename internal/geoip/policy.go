@@ -0,0 +1,179 @@
+package geoip
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// PolicyFile holds a site's geo policy as JSON in <RulesRoot>/<site>/.
+	PolicyFile = "geo-policy.json"
+	// RuleFile is the rendered rule file in <RulesRoot>/<site>/rules/. It
+	// sorts after zz-waf-admin-iplists.conf so allow-listed addresses are
+	// never geo blocked.
+	RuleFile = "zz-waf-admin-policy-geo.conf"
+
+	// IDBase is the first of the rule IDs used by RuleFile, up to IDBase+9.
+	IDBase = 99510
+
+	ModeAllow = "allow"
+	ModeDeny  = "deny"
+
+	ActionDeny      = "deny"
+	ActionLog       = "log"
+	ActionChallenge = "challenge"
+)
+
+// Continents are the continent codes GeoLite2 uses.
+var Continents = []string{"AF", "AN", "AS", "EU", "NA", "OC", "SA"}
+
+var (
+	countryRe   = regexp.MustCompile(`^[A-Z]{2}$`)
+	cookieRe    = regexp.MustCompile(`^[A-Za-z0-9!#$%&*+.^_|~-]+$`)
+	challengeRe = regexp.MustCompile(`^(?:https?://[^\s'",\\]+|/[^\s'",\\]*)$`)
+)
+
+// Policy restricts a site by client country. In allow mode only clients
+// from the listed countries or continents pass; in deny mode those are
+// the ones acted on. Clients the database cannot place always pass.
+type Policy struct {
+	Mode       string   `json:"mode"`
+	Countries  []string `json:"countries,omitempty"`
+	Continents []string `json:"continents,omitempty"`
+	Action     string   `json:"action"`
+	Status     int      `json:"status,omitempty"`
+	// ChallengeURL is where challenged clients are redirected to. Clients
+	// sending ChallengeCookie, set once the challenge is solved, pass.
+	ChallengeURL    string    `json:"challengeUrl,omitempty"`
+	ChallengeCookie string    `json:"challengeCookie,omitempty"`
+	Author          string    `json:"author"`
+	Updated         time.Time `json:"updated"`
+}
+
+// Validate checks the fields a client supplies, upper-cases codes and
+// fills in the default action and status.
+func (p *Policy) Validate() error {
+	for i, c := range p.Countries {
+		p.Countries[i] = strings.ToUpper(strings.TrimSpace(c))
+		if !countryRe.MatchString(p.Countries[i]) {
+			return fmt.Errorf("invalid country code %q, expected ISO 3166 alpha-2", c)
+		}
+	}
+	for i, c := range p.Continents {
+		p.Continents[i] = strings.ToUpper(strings.TrimSpace(c))
+		if !slices.Contains(Continents, p.Continents[i]) {
+			return fmt.Errorf("invalid continent %q, must be one of %s", c, strings.Join(Continents, ", "))
+		}
+	}
+	if p.Action == "" {
+		p.Action = ActionDeny
+	}
+	switch {
+	case p.Mode != ModeAllow && p.Mode != ModeDeny:
+		return errors.New("mode must be allow or deny")
+	case len(p.Countries)+len(p.Continents) == 0:
+		return errors.New("at least one country or continent is required")
+	}
+	switch p.Action {
+	case ActionDeny:
+		if p.Status == 0 {
+			p.Status = 403
+		}
+		if p.Status < 400 || p.Status > 599 {
+			return errors.New("status must be 4xx or 5xx for deny")
+		}
+	case ActionLog:
+		p.Status = 0
+	case ActionChallenge:
+		if p.Status == 0 {
+			p.Status = 302
+		}
+		if !slices.Contains([]int{301, 302, 303, 307}, p.Status) {
+			return errors.New("status must be 301, 302, 303 or 307 for challenge")
+		}
+		if !challengeRe.MatchString(p.ChallengeURL) {
+			return errors.New("challenge needs a challengeUrl without quotes, commas or spaces")
+		}
+		if p.ChallengeCookie != "" && !cookieRe.MatchString(p.ChallengeCookie) {
+			return errors.New("invalid challengeCookie")
+		}
+	default:
+		return errors.New("action must be deny, log or challenge")
+	}
+	return nil
+}
+
+func LoadPolicy(b []byte) (Policy, error) {
+	var p Policy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return p, fmt.Errorf("parse %s: %w", PolicyFile, err)
+	}
+	return p, nil
+}
+
+func MarshalPolicy(p Policy) []byte {
+	b, _ := json.MarshalIndent(p, "", "  ")
+	return append(b, '\n')
+}
+
+// RenderPolicy produces the site's rule file: a phase 1 @geoLookup followed
+// by one rule per list in deny mode, or a single chained rule in allow
+// mode that fires when neither the country nor the continent is listed.
+func RenderPolicy(site string, p Policy) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Managed by waf-admin, do not edit. Use /v1/sites/%s/geo-policy instead.\n", site)
+	fmt.Fprintf(&b, "SecRule REMOTE_ADDR \"@geoLookup\" \"id:%d,phase:1,t:none,pass,nolog\"\n", IDBase)
+
+	type cond struct{ variable, op string }
+	var exempt []cond
+	if p.Action == ActionChallenge && p.ChallengeCookie != "" {
+		exempt = append(exempt, cond{"&REQUEST_COOKIES:" + p.ChallengeCookie, "@eq 0"})
+	}
+	var action string
+	switch p.Action {
+	case ActionDeny:
+		action = fmt.Sprintf("deny,status:%d,log", p.Status)
+	case ActionLog:
+		action = "pass,log"
+	case ActionChallenge:
+		action = fmt.Sprintf("redirect:%s,status:%d,log", p.ChallengeURL, p.Status)
+	}
+	id := IDBase + 1
+	rule := func(conds []cond, msg string) {
+		for i, c := range conds {
+			indent, actions := "    ", "t:none"
+			if i == 0 {
+				indent = ""
+				actions = fmt.Sprintf("id:%d,phase:1,t:none,%s,msg:'%s',logdata:'%%{GEO.country_code}'", id, action, msg)
+			}
+			if i < len(conds)-1 {
+				actions += ",chain"
+			}
+			fmt.Fprintf(&b, "%sSecRule %s \"%s\" \"%s\"\n", indent, c.variable, c.op, actions)
+		}
+		id++
+	}
+	if p.Mode == ModeDeny {
+		if len(p.Countries) > 0 {
+			rule(append([]cond{{"GEO:country_code", "@pm " + strings.Join(p.Countries, " ")}}, exempt...), "Country denied by geo policy")
+		}
+		if len(p.Continents) > 0 {
+			rule(append([]cond{{"GEO:continent_code", "@pm " + strings.Join(p.Continents, " ")}}, exempt...), "Continent denied by geo policy")
+		}
+		return []byte(b.String())
+	}
+	var conds []cond
+	if len(p.Countries) > 0 {
+		conds = append(conds, cond{"GEO:country_code", "!@pm " + strings.Join(p.Countries, " ")})
+	}
+	if len(p.Continents) > 0 {
+		conds = append(conds, cond{"GEO:continent_code", "!@pm " + strings.Join(p.Continents, " ")})
+	}
+	rule(append(conds, exempt...), "Country not allowed by geo policy")
+	return []byte(b.String())
+}
@@ -0,0 +1,154 @@
+// Package hooks runs the commands and webhooks configured around an
+// apply: pre-apply hooks before the render driver validates a change,
+// post-apply hooks after the reload.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	Pre  = "pre"
+	Post = "post"
+)
+
+// maxOutput bounds the output kept of a hook, from its start.
+const maxOutput = 4096
+
+// Hook is an executable (Command) or a webhook (URL), never both.
+type Hook struct {
+	Name    string
+	Command []string
+	URL     string
+	Headers map[string]string
+	// Timeout bounds one run, 30s when zero.
+	Timeout time.Duration
+}
+
+// Event describes the apply a hook runs for. It is sent as JSON on the
+// command's stdin or as the webhook's POST body.
+type Event struct {
+	Stage string    `json:"stage"`
+	Sites []string  `json:"sites,omitempty"`
+	Paths []string  `json:"paths,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Result is the outcome of one hook run.
+type Result struct {
+	Name       string `json:"name"`
+	OK         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+	Output     string `json:"output,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Error is a failed pre-apply hook.
+type Error struct{ Result Result }
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("pre-apply hook %s failed: %s", e.Result.Name, e.Result.Error)
+	if e.Result.Output != "" {
+		msg += ": " + e.Result.Output
+	}
+	return msg
+}
+
+// RunPre runs hooks in order and stops at the first failure, returned as
+// an *Error.
+func RunPre(ctx context.Context, hooks []Hook, ev Event) error {
+	ev.Stage, ev.Time = Pre, time.Now().UTC()
+	for _, h := range hooks {
+		if res := h.Run(ctx, ev); !res.OK {
+			return &Error{Result: res}
+		}
+	}
+	return nil
+}
+
+// RunPost runs every hook regardless of failures.
+func RunPost(ctx context.Context, hooks []Hook, ev Event) []Result {
+	ev.Stage, ev.Time = Post, time.Now().UTC()
+	out := make([]Result, len(hooks))
+	for i, h := range hooks {
+		out[i] = h.Run(ctx, ev)
+	}
+	return out
+}
+
+// Run runs h once. A command fails with a non-zero exit, a webhook with a
+// status outside 2xx; both fail when they exceed the timeout.
+func (h Hook) Run(ctx context.Context, ev Event) Result {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	body, _ := json.Marshal(ev)
+
+	start := time.Now()
+	var out []byte
+	var err error
+	if h.URL != "" {
+		out, err = h.post(ctx, body)
+	} else {
+		out, err = h.exec(ctx, body)
+	}
+	res := Result{Name: h.Name, OK: err == nil, DurationMs: time.Since(start).Milliseconds()}
+	if len(out) > maxOutput {
+		out = out[:maxOutput]
+	}
+	res.Output = strings.TrimSpace(string(out))
+	if err != nil {
+		res.Error = err.Error()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			res.Error = "timed out after " + timeout.String()
+		}
+	}
+	return res
+}
+
+func (h Hook) exec(ctx context.Context, body []byte) ([]byte, error) {
+	if len(h.Command) == 0 {
+		return nil, errors.New("no command or url")
+	}
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	// children of a killed command may keep its output open
+	cmd.WaitDelay = time.Second
+	return cmd.CombinedOutput()
+}
+
+func (h Hook) post(ctx context.Context, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	out, _ := io.ReadAll(io.LimitReader(resp.Body, maxOutput))
+	if resp.StatusCode/100 != 2 {
+		return out, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return out, nil
+}
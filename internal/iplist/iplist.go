@@ -0,0 +1,184 @@
+package iplist
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// StateFile holds the lists as JSON, in <RulesRoot>/<site>/ for a site
+	// and in <RulesRoot>/<GlobalDir>/ for the global lists.
+	StateFile = "iplists.json"
+	// GlobalDir is hidden so it is never taken for a site.
+	GlobalDir = ".waf-admin"
+	// RuleFile is the rendered rule file in <RulesRoot>/<site>/rules/. It
+	// checks the global lists and the site's own lists.
+	RuleFile = "zz-waf-admin-iplists.conf"
+	// EarlyFile takes the place of RuleFile on sites with a pinned CRS. It
+	// lives in <RulesRoot>/<site>/early/ (crs.EarlyDir), which crs.conf
+	// loads ahead of the CRS rules, so allowlisted clients skip those too.
+	EarlyFile = "waf-admin-iplists.conf"
+
+	// IDBase is the first of the four rule IDs used by RuleFile.
+	IDBase = 99500
+
+	Allow = "allow"
+	Block = "block"
+)
+
+// Kinds are the list names accepted in URLs.
+var Kinds = []string{Allow, Block}
+
+func ValidKind(kind string) bool { return kind == Allow || kind == Block }
+
+// DataFile is the @ipMatchFromFile file of a list, next to StateFile.
+func DataFile(kind string) string { return "ip-" + kind + ".data" }
+
+// Entry is one address or CIDR range on a list.
+type Entry struct {
+	ID      string     `json:"id"`
+	CIDR    string     `json:"cidr"`
+	Comment string     `json:"comment,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Author  string     `json:"author"`
+	Created time.Time  `json:"created"`
+}
+
+// Validate checks the fields a client supplies and normalises CIDR; a
+// plain address becomes a /32 or /128.
+func (e *Entry) Validate() error {
+	s := strings.TrimSpace(e.CIDR)
+	if s == "" {
+		return errors.New("cidr is required")
+	}
+	var p netip.Prefix
+	if strings.Contains(s, "/") {
+		var err error
+		if p, err = netip.ParsePrefix(s); err != nil {
+			return errors.New("invalid cidr")
+		}
+	} else {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return errors.New("invalid cidr")
+		}
+		p = netip.PrefixFrom(a, a.BitLen())
+	}
+	if p.Addr().Zone() != "" {
+		return errors.New("invalid cidr")
+	}
+	e.CIDR = p.Masked().String()
+	return nil
+}
+
+func (e *Entry) Expired(now time.Time) bool {
+	return e.Expires != nil && !e.Expires.After(now)
+}
+
+func NewID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Lists is the content of a state file.
+type Lists struct {
+	Allow []Entry `json:"allow"`
+	Block []Entry `json:"block"`
+}
+
+// Get returns the list named kind, which must be valid.
+func (l *Lists) Get(kind string) *[]Entry {
+	if kind == Allow {
+		return &l.Allow
+	}
+	return &l.Block
+}
+
+// Load parses a state file; missing state is two empty lists.
+func Load(b []byte) (Lists, error) {
+	l := Lists{Allow: []Entry{}, Block: []Entry{}}
+	if len(b) == 0 {
+		return l, nil
+	}
+	if err := json.Unmarshal(b, &l); err != nil {
+		return l, fmt.Errorf("parse %s: %w", StateFile, err)
+	}
+	if l.Allow == nil {
+		l.Allow = []Entry{}
+	}
+	if l.Block == nil {
+		l.Block = []Entry{}
+	}
+	return l, nil
+}
+
+func Marshal(l Lists) []byte {
+	b, _ := json.MarshalIndent(l, "", "  ")
+	return append(b, '\n')
+}
+
+// Prune drops expired entries and reports whether any were dropped.
+func (l *Lists) Prune(now time.Time) bool {
+	pruned := false
+	for _, kind := range Kinds {
+		list := l.Get(kind)
+		kept := (*list)[:0:0]
+		for _, e := range *list {
+			if !e.Expired(now) {
+				kept = append(kept, e)
+			}
+		}
+		pruned = pruned || len(kept) != len(*list)
+		*list = kept
+	}
+	return pruned
+}
+
+// RenderData produces the data file of a list, one range per line.
+// Expired entries are left out.
+func RenderData(entries []Entry, now time.Time) []byte {
+	var b strings.Builder
+	b.WriteString("# Managed by waf-admin, do not edit.\n")
+	for _, e := range entries {
+		if e.Expired(now) {
+			continue
+		}
+		if e.Comment != "" {
+			// a newline in free text would end the comment
+			b.WriteString("# " + strings.NewReplacer("\r", " ", "\n", " ").Replace(e.Comment) + "\n")
+		}
+		b.WriteString(e.CIDR + "\n")
+	}
+	return []byte(b.String())
+}
+
+// RenderRules produces the site's rule file. globalDir and siteDir hold
+// the data files. Allowlisted clients skip every later rule of the
+// transaction through the allow action; ctl:ruleEngine=Off would only take
+// effect from the next phase on. Blocklisted clients are denied in phase 1.
+// Like deny, allow does nothing in DetectionOnly mode.
+func RenderRules(site, globalDir, siteDir string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Managed by waf-admin, do not edit. Use /v1/ip-lists and /v1/sites/%s/ip-lists instead.\n", site)
+	rules := []struct{ dir, kind, scope string }{
+		{globalDir, Allow, "global"}, {siteDir, Allow, "site"},
+		{globalDir, Block, "global"}, {siteDir, Block, "site"},
+	}
+	for i, r := range rules {
+		action := "allow,nolog"
+		if r.kind == Block {
+			action = fmt.Sprintf("deny,status:403,log,msg:'Client address is on the %s blocklist'", r.scope)
+		}
+		fmt.Fprintf(&b, "SecRule REMOTE_ADDR \"@ipMatchFromFile %s\" \\\n    \"id:%d,phase:1,t:none,%s\"\n",
+			filepath.Join(r.dir, DataFile(r.kind)), IDBase+i, action)
+	}
+	return []byte(b.String())
+}
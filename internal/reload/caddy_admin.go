@@ -1,94 +1,196 @@
 package reload
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Stack-Dash/waf-admin/internal/render"
 )
 
 type Reloader interface {
 	Reload(ctx context.Context) error
 }
 
+// CaddyAdmin reloads one Caddy instance through its admin API.
 type CaddyAdmin struct {
-	sock string
-	cfg  string
+	addr        string
+	config      func(ctx context.Context) ([]byte, error)
+	contentType string
+	sites       render.SiteRouter
+}
+
+// NewCaddyAdmin talks to the admin API at adminAddr: a unix socket path
+// ("/run/caddy/admin.sock" or "unix//run/caddy/admin.sock") or a TCP
+// address ("10.0.0.2:2019" or "http://10.0.0.2:2019"). Reload loads the
+// Caddyfile at caddyfile.
+func NewCaddyAdmin(adminAddr, caddyfile string) *CaddyAdmin {
+	return NewCaddyAdminConfig(adminAddr, "text/caddyfile", func(context.Context) ([]byte, error) {
+		return os.ReadFile(caddyfile)
+	})
 }
 
-func NewCaddyAdmin(adminSock, caddyfile string) *CaddyAdmin {
-	return &CaddyAdmin{sock: adminSock, cfg: caddyfile}
+// NewCaddyAdminConfig is like NewCaddyAdmin, but Reload loads whatever
+// config returns, sent as contentType.
+func NewCaddyAdminConfig(adminAddr, contentType string, config func(ctx context.Context) ([]byte, error)) *CaddyAdmin {
+	return &CaddyAdmin{addr: adminAddr, config: config, contentType: contentType}
 }
 
-func (c *CaddyAdmin) unixClient() *http.Client {
+// Addr is the admin address the instance was created with.
+func (c *CaddyAdmin) Addr() string { return c.addr }
+
+func (c *CaddyAdmin) socket() (string, bool) {
+	if path, ok := strings.CutPrefix(c.addr, "unix/"); ok {
+		return path, true
+	}
+	return c.addr, strings.HasPrefix(c.addr, "/")
+}
+
+func (c *CaddyAdmin) client() *http.Client {
+	sock, ok := c.socket()
+	if !ok {
+		return &http.Client{}
+	}
 	return &http.Client{Transport: &http.Transport{
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return net.Dial("unix", c.sock)
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
 		},
 	}}
 }
 
-// Stop triggers a graceful Caddy shutdown via the admin API.
-// Combined with a Docker restart policy of "condition: any", this
-// effectively restarts Caddy so it can pick up changed files on disk.
-func (c *CaddyAdmin) Stop(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, "POST", "http://unix/stop", nil)
+func (c *CaddyAdmin) url(path string) string {
+	if _, ok := c.socket(); ok {
+		return "http://unix" + path
+	}
+	if strings.Contains(c.addr, "://") {
+		return strings.TrimSuffix(c.addr, "/") + path
+	}
+	return "http://" + c.addr + path
+}
+
+func (c *CaddyAdmin) do(ctx context.Context, method, path, contentType string, body []byte) ([]byte, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path), rd)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
 	}
-	resp, err := c.unixClient().Do(req)
+	req.Header.Set("Cache-Control", "must-revalidate")
+	resp, err := c.client().Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode/100 != 2 {
-		b, _ := io.ReadAll(resp.Body)
-		return &ReloadError{Status: resp.StatusCode, Body: string(b)}
+		return nil, &ReloadError{Status: resp.StatusCode, Body: string(b)}
 	}
-	return nil
+	return b, nil
 }
 
+// Stop triggers a graceful Caddy shutdown via the admin API.
+// Combined with a Docker restart policy of "condition: any", this
+// effectively restarts Caddy so it can pick up changed files on disk.
+func (c *CaddyAdmin) Stop(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodPost, "/stop", "", nil)
+	return err
+}
+
+// NewCaddyAdminIncremental loads the JSON config of sr. When the context
+// names the changed sites (see WithSites), Reload only replaces their
+// routes through /id/<render.RouteID(site)>, and falls back to loading the
+// full config if that fails.
+func NewCaddyAdminIncremental(adminAddr string, sr render.SiteRouter) *CaddyAdmin {
+	c := NewCaddyAdminConfig(adminAddr, "application/json", sr.Config)
+	c.sites = sr
+	return c
+}
+
+// Reload loads the config. An incremental reload that patched some routes
+// before failing falls back to the full config; if that fails too, the
+// config from before the patches is restored, so a failed Reload leaves
+// the instance as it was.
 func (c *CaddyAdmin) Reload(ctx context.Context) error {
-	body, err := os.ReadFile(c.cfg)
-	if err != nil {
-		return err
+	var before []byte
+	if sites := Sites(ctx); c.sites != nil && sites != nil {
+		snap, err := c.Snapshot(ctx)
+		if err == nil {
+			before = snap
+			err = c.patchSites(ctx, sites)
+			if err == nil {
+				return nil
+			}
+		}
+		log.Warn().Err(err).Str("admin", c.addr).Strs("sites", sites).Msg("incremental reload failed, loading full config")
 	}
-	req, err := http.NewRequestWithContext(ctx, "POST", "http://unix/load", io.NopCloser(bytesReader(body)))
-	if err != nil {
-		return err
+	err := c.load(ctx)
+	if err != nil && before != nil {
+		rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+		defer cancel()
+		if rerr := c.Restore(rctx, before); rerr != nil {
+			log.Error().Err(rerr).Str("admin", c.addr).Msg("restoring config after failed reload failed")
+		}
 	}
-	req.Header.Set("Content-Type", "text/caddyfile")
-	req.Header.Set("Cache-Control", "must-revalidate")
-	resp, err := c.unixClient().Do(req)
+	return err
+}
+
+func (c *CaddyAdmin) load(ctx context.Context) error {
+	body, err := c.config(ctx)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode/100 != 2 {
-		b, _ := io.ReadAll(resp.Body)
-		return &ReloadError{Status: resp.StatusCode, Body: string(b)}
+	_, err = c.do(ctx, http.MethodPost, "/load", c.contentType, body)
+	return err
+}
+
+// patchSites replaces the routes of sites in the running config, or
+// removes them for sites that no longer exist.
+func (c *CaddyAdmin) patchSites(ctx context.Context, sites []string) error {
+	for _, site := range sites {
+		route, err := c.sites.SiteRoute(ctx, site)
+		if err != nil {
+			return err
+		}
+		path := "/id/" + render.RouteID(site)
+		if route == nil {
+			_, err = c.do(ctx, http.MethodDelete, path, "", nil)
+		} else {
+			_, err = c.do(ctx, http.MethodPatch, path, "application/json", route)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, errText(err))
+		}
 	}
 	return nil
 }
 
+// Snapshot returns the running config as JSON, for Restore.
+func (c *CaddyAdmin) Snapshot(ctx context.Context) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, "/config/", "", nil)
+}
+
+// Restore loads a config taken with Snapshot.
+func (c *CaddyAdmin) Restore(ctx context.Context, config []byte) error {
+	_, err := c.do(ctx, http.MethodPost, "/load", "application/json", config)
+	return err
+}
+
 type ReloadError struct {
 	Status int
 	Body   string
 }
 
 func (e *ReloadError) Error() string { return "caddy reload failed" }
-
-func bytesReader(b []byte) *byteReader { return &byteReader{b: b} }
-
-type byteReader struct{ b []byte }
-
-func (r *byteReader) Read(p []byte) (int, error) {
-	if len(r.b) == 0 {
-		return 0, io.EOF
-	}
-	n := copy(p, r.b)
-	r.b = r.b[n:]
-	return n, nil
-}
-func (r *byteReader) Close() error { return nil }
@@ -0,0 +1,108 @@
+package reload
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Probe is an HTTP check against a URL served by the canary instance.
+type Probe struct {
+	URL string
+	// Status is the expected response status, 200 when zero.
+	Status int
+}
+
+// BlockCounter counts the audited transactions of the given instances in
+// [from, to), and how many of them were blocked.
+type BlockCounter func(instances []string, from, to time.Time) (total, blocked int, err error)
+
+// Canary makes a FanOut reload one instance first and keep it under
+// observation for Soak before reloading the rest.
+type Canary struct {
+	// Instance is the canary's name, the first instance when empty.
+	Instance string
+	Soak     time.Duration
+	// Interval between probe rounds, 10s when zero.
+	Interval time.Duration
+	Probes   []Probe
+
+	// Blocks, when set, compares the canary's block rate during the soak
+	// with that of the instances still on the previous config. The canary
+	// fails when its rate exceeds theirs by more than MaxBlockRateIncrease
+	// (0.05 is five percentage points), once it logged at least MinEvents.
+	Blocks               BlockCounter
+	MaxBlockRateIncrease float64
+	MinEvents            int
+}
+
+// watch probes the canary until the soak period is over, then compares
+// block rates. It returns why the canary is unhealthy, or nil.
+func (c *Canary) watch(ctx context.Context, canary string, others []string) error {
+	start := time.Now()
+	interval := c.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	for {
+		for _, p := range c.Probes {
+			if err := p.check(ctx, client); err != nil {
+				return err
+			}
+		}
+		left := c.Soak - time.Since(start)
+		if left <= 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(min(interval, left)):
+		}
+	}
+	if c.Blocks == nil || len(others) == 0 {
+		return nil
+	}
+	end := time.Now()
+	total, blocked, err := c.Blocks([]string{canary}, start, end)
+	if err != nil {
+		return fmt.Errorf("count canary events: %w", err)
+	}
+	if total == 0 || total < c.MinEvents {
+		return nil
+	}
+	baseTotal, baseBlocked, err := c.Blocks(others, start, end)
+	if err != nil {
+		return fmt.Errorf("count baseline events: %w", err)
+	}
+	rate, base := float64(blocked)/float64(total), 0.0
+	if baseTotal > 0 {
+		base = float64(baseBlocked) / float64(baseTotal)
+	}
+	if rate-base > c.MaxBlockRateIncrease {
+		return fmt.Errorf("block rate %.1f%% (%d/%d) against %.1f%% on the other instances", rate*100, blocked, total, base*100)
+	}
+	return nil
+}
+
+func (p Probe) check(ctx context.Context, client *http.Client) error {
+	want := p.Status
+	if want == 0 {
+		want = http.StatusOK
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("probe %s: %w", p.URL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != want {
+		return fmt.Errorf("probe %s: status %d, want %d", p.URL, resp.StatusCode, want)
+	}
+	return nil
+}
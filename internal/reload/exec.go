@@ -0,0 +1,28 @@
+package reload
+
+import (
+	"context"
+
+	"github.com/Stack-Dash/waf-admin/internal/execproto"
+)
+
+// Exec reloads through an external executable speaking execproto. The
+// request carries the changed paths and sites, see execproto.WithPaths and
+// WithSites.
+type Exec struct {
+	cmd *execproto.Command
+}
+
+func NewExec(cmd *execproto.Command) *Exec { return &Exec{cmd: cmd} }
+
+func (e *Exec) Reload(ctx context.Context) error {
+	_, err := e.cmd.Run(ctx, execproto.Reload, execproto.Request{Paths: execproto.Paths(ctx), Sites: Sites(ctx)})
+	return err
+}
+
+// Stop asks the executable to shut the proxy down. Like CaddyAdmin.Stop it
+// relies on a supervisor to start it again.
+func (e *Exec) Stop(ctx context.Context) error {
+	_, err := e.cmd.Run(ctx, execproto.Stop, execproto.Request{})
+	return err
+}
@@ -0,0 +1,179 @@
+package reload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Instance is one Caddy behind a FanOut.
+type Instance struct {
+	Name  string
+	Admin *CaddyAdmin
+}
+
+// Result is the outcome of the last reload on one instance.
+type Result struct {
+	Instance string `json:"instance"`
+	Address  string `json:"address"`
+	// Status is "reloaded", "failed", "rolled-back" (reloaded, then
+	// restored because another instance failed) or "skipped".
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+const (
+	StatusReloaded   = "reloaded"
+	StatusFailed     = "failed"
+	StatusRolledBack = "rolled-back"
+	StatusSkipped    = "skipped"
+)
+
+// FanOutError is returned when an instance could not be reloaded. Every
+// instance reloaded before it has been restored to its previous config.
+type FanOutError struct {
+	Results []Result
+}
+
+func (e *FanOutError) Error() string {
+	var failed, restored []string
+	for _, r := range e.Results {
+		if r.Error != "" {
+			failed = append(failed, r.Instance+": "+r.Error)
+		}
+		if r.Status == StatusRolledBack {
+			restored = append(restored, r.Instance)
+		}
+	}
+	msg := "caddy reload failed on " + strings.Join(failed, "; ")
+	if len(restored) > 0 {
+		msg += "; rolled back " + strings.Join(restored, ", ")
+	}
+	return msg
+}
+
+// FanOut reloads several Caddy instances that share the same files, one
+// after the other. Before reloading it takes a snapshot of every running
+// config; if any instance fails, the ones already reloaded get their
+// snapshot back, so all instances keep running the same config.
+//
+// With a Canary the canary instance goes first and the others are only
+// reloaded once it has stayed healthy for the soak period.
+type FanOut struct {
+	instances []Instance
+	canary    *Canary
+
+	mu   sync.Mutex
+	last []Result
+}
+
+// NewFanOut reloads instances in order, or the canary first when canary is
+// not nil. It fails if the canary is not among the instances.
+func NewFanOut(instances []Instance, canary *Canary) (*FanOut, error) {
+	f := &FanOut{instances: instances, canary: canary}
+	if canary == nil || canary.Instance == "" {
+		return f, nil
+	}
+	for i, in := range instances {
+		if in.Name == canary.Instance {
+			f.instances = append([]Instance{in}, append(slices.Clone(instances[:i]), instances[i+1:]...)...)
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("canary %q is not one of the instances", canary.Instance)
+}
+
+func (f *FanOut) Reload(ctx context.Context) error {
+	results := make([]Result, len(f.instances))
+	for i, in := range f.instances {
+		results[i] = Result{Instance: in.Name, Address: in.Admin.Addr(), Status: StatusSkipped}
+	}
+	defer func() {
+		f.mu.Lock()
+		f.last = results
+		f.mu.Unlock()
+	}()
+
+	snapshots := make([][]byte, len(f.instances))
+	for i, in := range f.instances {
+		b, err := in.Admin.Snapshot(ctx)
+		if err != nil {
+			results[i].Status, results[i].Error = StatusFailed, "snapshot: "+errText(err)
+			return &FanOutError{Results: results}
+		}
+		snapshots[i] = b
+	}
+	for i, in := range f.instances {
+		if err := in.Admin.Reload(ctx); err != nil {
+			results[i].Status, results[i].Error = StatusFailed, errText(err)
+			f.rollback(ctx, results[:i], snapshots)
+			return &FanOutError{Results: results}
+		}
+		results[i].Status = StatusReloaded
+		if i == 0 && f.canary != nil && len(f.instances) > 1 {
+			var others []string
+			for _, o := range f.instances[1:] {
+				others = append(others, o.Name)
+			}
+			if err := f.canary.watch(ctx, in.Name, others); err != nil {
+				f.rollback(ctx, results[:1], snapshots)
+				msg := "canary unhealthy: " + err.Error()
+				if results[0].Error != "" {
+					msg += "; " + results[0].Error
+				}
+				results[0].Error = msg
+				return &FanOutError{Results: results}
+			}
+		}
+	}
+	return nil
+}
+
+// rollbackTimeout bounds restoring snapshots after a failed reload.
+const rollbackTimeout = 30 * time.Second
+
+// rollback restores the snapshots of the reloaded instances in results.
+// It runs even when ctx, the request's, has been cancelled, since the
+// instances would otherwise keep running different configs.
+func (f *FanOut) rollback(ctx context.Context, results []Result, snapshots [][]byte) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	for i := range results {
+		if err := f.instances[i].Admin.Restore(ctx, snapshots[i]); err != nil {
+			results[i].Error = "rollback: " + errText(err)
+			continue
+		}
+		results[i].Status = StatusRolledBack
+	}
+}
+
+// Results returns the per-instance outcome of the last reload, empty
+// before the first one.
+func (f *FanOut) Results() []Result {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Result{}, f.last...)
+}
+
+// Stop stops every instance, see CaddyAdmin.Stop.
+func (f *FanOut) Stop(ctx context.Context) error {
+	var errs []error
+	for _, in := range f.instances {
+		if err := in.Admin.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", in.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func errText(err error) string {
+	var re *ReloadError
+	if errors.As(err, &re) && re.Body != "" {
+		return fmt.Sprintf("%s: %d %s", err, re.Status, strings.TrimSpace(re.Body))
+	}
+	return err.Error()
+}
@@ -0,0 +1,40 @@
+package reload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// PidFile signals the process whose pid is in a file, such as the nginx
+// master: SIGHUP to reload its configuration, SIGQUIT to shut down
+// gracefully.
+type PidFile struct {
+	path string
+}
+
+func NewPidFile(path string) *PidFile { return &PidFile{path: path} }
+
+func (p *PidFile) Reload(ctx context.Context) error { return p.signal(syscall.SIGHUP) }
+
+// Stop shuts the process down gracefully. Like CaddyAdmin.Stop it relies
+// on a supervisor to start it again.
+func (p *PidFile) Stop(ctx context.Context) error { return p.signal(syscall.SIGQUIT) }
+
+func (p *PidFile) signal(sig syscall.Signal) error {
+	b, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("invalid pid in %s", p.path)
+	}
+	if err := syscall.Kill(pid, sig); err != nil {
+		return fmt.Errorf("signal pid %d from %s: %w", pid, p.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package reload
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startFakeNginx runs examples/nginx/fake-nginx.sh, which writes its pid to
+// pidFile and prints a line for every SIGHUP and SIGQUIT, and returns
+// those lines.
+func startFakeNginx(t *testing.T, pidFile string) (<-chan string, *exec.Cmd) {
+	t.Helper()
+	cmd := exec.Command("../../examples/nginx/fake-nginx.sh", "-p", pidFile)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(out)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+	waitLine(t, lines, "running as")
+	return lines, cmd
+}
+
+func waitLine(t *testing.T, lines <-chan string, want string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("fake nginx exited before printing %q", want)
+			}
+			if strings.Contains(line, want) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("fake nginx did not print %q", want)
+		}
+	}
+}
+
+func TestPidFileReloadAndStop(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "nginx.pid")
+	lines, cmd := startFakeNginx(t, pidFile)
+	p := NewPidFile(pidFile)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := p.Reload(ctx); err != nil {
+			t.Fatalf("Reload: %v", err)
+		}
+		waitLine(t, lines, "reload")
+	}
+	if err := p.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	waitLine(t, lines, "quit")
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("fake nginx: %v", err)
+	}
+	if _, err := os.Stat(pidFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("pid file left behind: %v", err)
+	}
+}
+
+func TestPidFileErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	// a process that has exited, whose pid is stale
+	done := exec.Command("true")
+	if err := done.Run(); err != nil {
+		t.Fatal(err)
+	}
+	stale := strconv.Itoa(done.Process.Pid)
+
+	tests := []struct {
+		name string
+		pid  string // pid file content, no file when empty
+		err  string
+		is   error
+	}{
+		{name: "missing pid file", is: os.ErrNotExist},
+		{name: "not a number", pid: "nginx\n", err: "invalid pid in "},
+		{name: "not a pid", pid: "0\n", err: "invalid pid in "},
+		{name: "stale pid", pid: stale + "\n", err: "signal pid " + stale + " from ", is: syscall.ESRCH},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".pid")
+			if tt.pid != "" {
+				if err := os.WriteFile(path, []byte(tt.pid), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := NewPidFile(path).Reload(context.Background())
+			if err == nil {
+				t.Fatal("Reload succeeded")
+			}
+			if tt.err != "" && !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Reload: %v, want error containing %q", err, tt.err)
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Fatalf("Reload: %v, want %v", err, tt.is)
+			}
+		})
+	}
+}
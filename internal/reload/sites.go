@@ -0,0 +1,17 @@
+package reload
+
+import "context"
+
+type sitesKey struct{}
+
+// WithSites records on ctx which sites a reload is for. nil means all of
+// them, as does a context without sites.
+func WithSites(ctx context.Context, sites []string) context.Context {
+	return context.WithValue(ctx, sitesKey{}, sites)
+}
+
+// Sites returns the sites recorded with WithSites, nil for all.
+func Sites(ctx context.Context) []string {
+	sites, _ := ctx.Value(sitesKey{}).([]string)
+	return sites
+}
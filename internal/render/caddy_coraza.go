@@ -3,11 +3,15 @@ package render
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 type CaddyOptions struct {
@@ -15,40 +19,159 @@ type CaddyOptions struct {
 	Caddyfile   string
 	SitesDir    string
 	RulesRoot   string
+	// ScratchDir holds temporary layout copies for dry runs. It must be
+	// visible to Caddy under the same path. Defaults to <SitesDir>/.scratch.
+	ScratchDir string
 }
 
 type CaddyCoraza struct{ CaddyOptions }
 
-func NewCaddyCoraza(o CaddyOptions) *CaddyCoraza { return &CaddyCoraza{CaddyOptions: o} }
+func NewCaddyCoraza(o CaddyOptions) *CaddyCoraza {
+	if o.ScratchDir == "" {
+		o.ScratchDir = filepath.Join(o.SitesDir, ".scratch")
+	}
+	return &CaddyCoraza{CaddyOptions: o}
+}
 
 func (c *CaddyCoraza) LayoutSites() string     { return c.SitesDir }
 func (c *CaddyCoraza) LayoutRulesRoot() string { return c.RulesRoot }
+func (c *CaddyCoraza) SiteExtension() string   { return ".caddy" }
+
+// SiteHosts takes the host names from the addresses in front of the
+// snippet's top-level blocks.
+func (c *CaddyCoraza) SiteHosts(src []byte) []string {
+	var hosts []string
+	depth := 0
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		// "(name) {" defines a reusable snippet, a bare "{" global options
+		if i := strings.Index(line, "{"); depth == 0 && i > 0 && !strings.HasPrefix(line, "(") {
+			for _, addr := range strings.FieldsFunc(line[:i], func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+				if h := addrHost(addr); h != "" {
+					hosts = append(hosts, h)
+				}
+			}
+		}
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+	}
+	return hosts
+}
+
+// addrHost reduces a Caddy site address such as https://example.com:8443/x
+// to its host name.
+func addrHost(addr string) string {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = addr[i+3:]
+	}
+	addr, _, _ = strings.Cut(addr, "/")
+	if h, _, ok := strings.Cut(addr, ":"); ok {
+		addr = h
+	}
+	return strings.ToLower(addr)
+}
 
 func (c *CaddyCoraza) Validate(ctx context.Context) error {
 	body, err := os.ReadFile(c.Caddyfile)
 	if err != nil {
 		return err
 	}
+	_, err = c.adapt(ctx, body)
+	return err
+}
 
+type adaptResponse struct {
+	Result   json.RawMessage `json:"result"`
+	Warnings []struct {
+		File      string `json:"file"`
+		Line      int    `json:"line"`
+		Directive string `json:"directive"`
+		Message   string `json:"message"`
+	} `json:"warnings"`
+}
+
+// adapt posts a Caddyfile to the admin /adapt endpoint, which fails if it
+// does not parse or adapt.
+func (c *CaddyCoraza) adapt(ctx context.Context, body []byte) (*adaptResponse, error) {
 	client := &http.Client{Transport: c.unixTransport()}
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://unix/adapt", bytes.NewReader(body))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "text/caddyfile")
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	msg, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
 	if resp.StatusCode/100 != 2 {
-		msg, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("caddy adapt failed: %s", bytes.TrimSpace(msg))
+		return nil, fmt.Errorf("caddy adapt failed: %s", bytes.TrimSpace(msg))
+	}
+	var out adaptResponse
+	if err := json.Unmarshal(msg, &out); err != nil {
+		return nil, fmt.Errorf("caddy adapt: decode response: %w", err)
+	}
+	return &out, nil
+}
+
+// DryRun copies the Caddyfile, SitesDir and RulesRoot into a scratch
+// directory with overlay applied, rewriting references to the live
+// directories, and adapts both the live and the scratch Caddyfile. Paths in
+// the candidate config are mapped back to the live ones so the two can be
+// compared directly.
+func (c *CaddyCoraza) DryRun(ctx context.Context, overlay map[string][]byte) (*DryRunResult, error) {
+	live, err := os.ReadFile(c.Caddyfile)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := c.adapt(ctx, live)
+	if err != nil {
+		return nil, fmt.Errorf("live config: %w", err)
+	}
+
+	if err := os.MkdirAll(c.ScratchDir, 0o755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(c.ScratchDir, "dryrun-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	sc := newScratch(c.CaddyOptions, dir)
+	candidate, err := sc.build(overlay)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &DryRunResult{Current: indentJSON(cur.Result)}
+	cand, err := c.adapt(ctx, candidate)
+	if err != nil {
+		res.Err = errors.New(sc.unrewrite(err.Error()))
+		return res, nil
+	}
+	res.Candidate = indentJSON([]byte(sc.unrewrite(string(cand.Result))))
+	for _, w := range cand.Warnings {
+		res.Warnings = append(res.Warnings, sc.unrewrite(fmt.Sprintf("%s:%d: %s: %s", w.File, w.Line, w.Directive, w.Message)))
+	}
+	return res, nil
+}
+
+func indentJSON(b []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", "  "); err != nil {
+		return b
 	}
-	io.Copy(io.Discard, resp.Body)
-	return nil
+	buf.WriteByte('\n')
+	return buf.Bytes()
 }
 
 func (c *CaddyCoraza) unixTransport() *http.Transport {
@@ -0,0 +1,235 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/corazawaf/coraza/v3"
+)
+
+type JSONOptions struct {
+	// BaseConfig is a complete Caddy JSON config without the sites.
+	BaseConfig string
+	// Server is the server in apps.http.servers whose routes the sites
+	// are appended to.
+	Server    string
+	SitesDir  string
+	RulesRoot string
+	// ScratchDir holds temporary layout copies for dry runs. Defaults to
+	// <SitesDir>/.scratch.
+	ScratchDir string
+}
+
+// CaddyJSON manages sites as Caddy JSON route fragments, <site>.json in
+// SitesDir, each holding one route object or an array of routes. Config
+// assembles them, in site name order, into the routes of Server in
+// BaseConfig; a site's only route is tagged with RouteID. Caddy has no
+// endpoint to validate a JSON config without loading it, so Validate
+// checks the assembled structure and compiles the directives of every
+// Coraza "waf" handler.
+type CaddyJSON struct{ JSONOptions }
+
+func NewCaddyJSON(o JSONOptions) *CaddyJSON {
+	if o.ScratchDir == "" {
+		o.ScratchDir = filepath.Join(o.SitesDir, ".scratch")
+	}
+	return &CaddyJSON{JSONOptions: o}
+}
+
+func (c *CaddyJSON) LayoutSites() string     { return c.SitesDir }
+func (c *CaddyJSON) LayoutRulesRoot() string { return c.RulesRoot }
+func (c *CaddyJSON) SiteExtension() string   { return ".json" }
+
+// SiteHosts returns the hosts the fragment's routes match on.
+func (c *CaddyJSON) SiteHosts(src []byte) []string {
+	routes, err := parseRoutes(src)
+	if err != nil {
+		return nil
+	}
+	var hosts []string
+	for _, r := range routes {
+		var route struct {
+			Match []struct {
+				Host []string `json:"host"`
+			} `json:"match"`
+		}
+		if json.Unmarshal(r, &route) != nil {
+			continue
+		}
+		for _, m := range route.Match {
+			for _, h := range m.Host {
+				hosts = append(hosts, strings.ToLower(h))
+			}
+		}
+	}
+	return hosts
+}
+
+// Config returns the assembled config as loaded into Caddy.
+func (c *CaddyJSON) Config(ctx context.Context) ([]byte, error) {
+	base, err := os.ReadFile(c.BaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	return c.assemble(base)
+}
+
+func (c *CaddyJSON) Validate(ctx context.Context) error {
+	cfg, err := c.Config(ctx)
+	if err != nil {
+		return err
+	}
+	return checkWAFs(cfg)
+}
+
+// assemble appends the routes of every site fragment to the routes of
+// c.Server in base.
+func (c *CaddyJSON) assemble(base []byte) ([]byte, error) {
+	var cfg map[string]any
+	if err := json.Unmarshal(base, &cfg); err != nil {
+		return nil, fmt.Errorf("base config: %w", err)
+	}
+	server, ok := lookup(cfg, "apps", "http", "servers", c.Server).(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("base config has no apps.http.servers.%s", c.Server)
+	}
+	routes, _ := server["routes"].([]any)
+
+	ents, err := os.ReadDir(c.SitesDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var names []string
+	for _, e := range ents {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), c.SiteExtension()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b, err := os.ReadFile(filepath.Join(c.SitesDir, name))
+		if err != nil {
+			return nil, err
+		}
+		site, err := parseRoutes(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, r := range site {
+			var route map[string]any
+			_ = json.Unmarshal(r, &route)
+			if _, ok := route["@id"]; !ok && len(site) == 1 {
+				route["@id"] = RouteID(strings.TrimSuffix(name, c.SiteExtension()))
+			}
+			routes = append(routes, route)
+		}
+	}
+	server["routes"] = routes
+	return json.Marshal(cfg)
+}
+
+// parseRoutes reads a site fragment: one route object or an array of them.
+func parseRoutes(src []byte) ([]json.RawMessage, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(src, &list); err != nil {
+		var one map[string]json.RawMessage
+		if err := json.Unmarshal(src, &one); err != nil {
+			return nil, errors.New("expected a route object or an array of routes")
+		}
+		list = []json.RawMessage{src}
+	}
+	for i, r := range list {
+		var route map[string]json.RawMessage
+		if err := json.Unmarshal(r, &route); err != nil {
+			return nil, fmt.Errorf("route %d is not an object", i)
+		}
+	}
+	return list, nil
+}
+
+func lookup(v any, keys ...string) any {
+	for _, k := range keys {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// checkWAFs compiles the directives of every "waf" handler in cfg.
+// Handlers loading the CRS embedded in the plugin (load_owasp_crs) cannot
+// be compiled here and are skipped.
+func checkWAFs(cfg []byte) error {
+	var root any
+	if err := json.Unmarshal(cfg, &root); err != nil {
+		return err
+	}
+	var errs []error
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if v["handler"] == "waf" {
+				directives, _ := v["directives"].(string)
+				if crs, _ := v["load_owasp_crs"].(bool); directives != "" && !crs {
+					if _, err := coraza.NewWAF(coraza.NewWAFConfig().WithDirectives(directives)); err != nil {
+						errs = append(errs, fmt.Errorf("waf handler: %w", err))
+					}
+				}
+			}
+			for _, e := range v {
+				walk(e)
+			}
+		case []any:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	walk(root)
+	return errors.Join(errs...)
+}
+
+// DryRun copies the base config, sites and rules into a scratch directory
+// with overlay applied, rewriting references to the live directories, and
+// assembles and validates the copy.
+func (c *CaddyJSON) DryRun(ctx context.Context, overlay map[string][]byte) (*DryRunResult, error) {
+	cur, err := c.Config(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("live config: %w", err)
+	}
+	if err := os.MkdirAll(c.ScratchDir, 0o755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(c.ScratchDir, "dryrun-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	sc := newScratch(CaddyOptions{Caddyfile: c.BaseConfig, SitesDir: c.SitesDir, RulesRoot: c.RulesRoot, ScratchDir: c.ScratchDir}, dir)
+	base, err := sc.build(overlay)
+	if err != nil {
+		return nil, err
+	}
+	res := &DryRunResult{Current: indentJSON(cur)}
+	scratchDriver := &CaddyJSON{JSONOptions{Server: c.Server, SitesDir: sc.sitesDir, RulesRoot: sc.rulesRoot}}
+	cand, err := scratchDriver.assemble(base)
+	if err == nil {
+		err = checkWAFs(cand)
+	}
+	if err != nil {
+		res.Err = errors.New(sc.unrewrite(err.Error()))
+		return res, nil
+	}
+	res.Candidate = indentJSON([]byte(sc.unrewrite(string(cand))))
+	return res, nil
+}
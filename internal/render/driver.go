@@ -5,5 +5,26 @@ import "context"
 type Driver interface {
 	LayoutSites() string
 	LayoutRulesRoot() string
+	// SiteExtension is the file name extension of site files in
+	// LayoutSites, such as ".caddy".
+	SiteExtension() string
+	// SiteHosts returns the host names a site file serves.
+	SiteHosts(src []byte) []string
 	Validate(ctx context.Context) error
 }
+
+// DryRunner is implemented by drivers that can validate a candidate layout
+// without touching the live one. Overlay maps live file paths to their
+// candidate content; a nil value means the file is removed.
+type DryRunner interface {
+	DryRun(ctx context.Context, overlay map[string][]byte) (*DryRunResult, error)
+}
+
+// DryRunResult holds the adapted config of the live and the candidate
+// layout. Err is set when the candidate fails validation.
+type DryRunResult struct {
+	Current   []byte
+	Candidate []byte
+	Warnings  []string
+	Err       error
+}
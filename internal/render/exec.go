@@ -0,0 +1,78 @@
+package render
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/Stack-Dash/waf-admin/internal/execproto"
+)
+
+// Exec delegates validation to an external executable speaking
+// execproto, for proxies or checks waf-admin has no driver for. The
+// executable gets the changed paths (see execproto.WithPaths) and, when
+// Sites is set, the changed sites.
+type Exec struct {
+	cmd *execproto.Command
+	// Sites returns the sites a validation is for, nil for all.
+	Sites func(ctx context.Context) []string
+
+	mu    sync.Mutex
+	hosts map[[sha256.Size]byte][]string
+}
+
+// maxHostsCache bounds the SiteHosts answers kept; the cache is emptied
+// when it is full.
+const maxHostsCache = 1024
+
+// NewExec runs command for every operation. Site files end in ext.
+func NewExec(command []string, timeout time.Duration, sitesDir, rulesRoot, ext string) *Exec {
+	return &Exec{cmd: &execproto.Command{
+		Args:    command,
+		Timeout: timeout,
+		Layout:  execproto.Layout{SitesDir: sitesDir, RulesRoot: rulesRoot, SiteExtension: ext},
+	}}
+}
+
+// Command is the executable, for use as a reloader.
+func (e *Exec) Command() *execproto.Command { return e.cmd }
+
+func (e *Exec) LayoutSites() string     { return e.cmd.Layout.SitesDir }
+func (e *Exec) LayoutRulesRoot() string { return e.cmd.Layout.RulesRoot }
+func (e *Exec) SiteExtension() string   { return e.cmd.Layout.SiteExtension }
+
+// SiteHosts asks the executable for the hosts of a site file. Executables
+// that do not know them answer with no hosts, so audit events of their
+// sites are only attributed by rule file. Answers are cached by content,
+// since the host to site mapping of audit events asks for every site again
+// each minute; failed runs are not cached.
+func (e *Exec) SiteHosts(src []byte) []string {
+	sum := sha256.Sum256(src)
+	e.mu.Lock()
+	hosts, ok := e.hosts[sum]
+	e.mu.Unlock()
+	if ok {
+		return hosts
+	}
+	resp, err := e.cmd.Run(context.Background(), execproto.Hosts, execproto.Request{Content: string(src)})
+	if err != nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.hosts == nil || len(e.hosts) >= maxHostsCache {
+		e.hosts = map[[sha256.Size]byte][]string{}
+	}
+	e.hosts[sum] = resp.Hosts
+	return resp.Hosts
+}
+
+func (e *Exec) Validate(ctx context.Context) error {
+	req := execproto.Request{Paths: execproto.Paths(ctx)}
+	if e.Sites != nil {
+		req.Sites = e.Sites(ctx)
+	}
+	_, err := e.cmd.Run(ctx, execproto.Validate, req)
+	return err
+}
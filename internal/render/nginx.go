@@ -0,0 +1,73 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+type NginxOptions struct {
+	// Config is the main nginx.conf, which includes the site files.
+	Config    string
+	SitesDir  string
+	RulesRoot string
+	// TestCommand checks the configuration, "{config}" is replaced with
+	// Config. Defaults to nginx -t -q -c {config}.
+	TestCommand []string
+}
+
+// Nginx manages sites as nginx server include files, <site>.conf in
+// SitesDir, with ModSecurity v3 rules under RulesRoot referenced through
+// modsecurity_rules_file. Validate runs TestCommand, which makes nginx
+// load the site files and every rule file they reference.
+type Nginx struct{ NginxOptions }
+
+func NewNginx(o NginxOptions) *Nginx {
+	if len(o.TestCommand) == 0 {
+		o.TestCommand = []string{"nginx", "-t", "-q", "-c", "{config}"}
+	}
+	return &Nginx{NginxOptions: o}
+}
+
+func (n *Nginx) LayoutSites() string     { return n.SitesDir }
+func (n *Nginx) LayoutRulesRoot() string { return n.RulesRoot }
+func (n *Nginx) SiteExtension() string   { return ".conf" }
+
+// SiteHosts returns the names of the server_name directives. Wildcard
+// and regular expression names and the catch-all "_" are left out.
+func (n *Nginx) SiteHosts(src []byte) []string {
+	var hosts []string
+	for _, line := range strings.Split(string(src), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		for _, stmt := range strings.Split(line, ";") {
+			f := strings.Fields(stmt)
+			if len(f) < 2 || f[0] != "server_name" {
+				continue
+			}
+			for _, name := range f[1:] {
+				if name == "_" || strings.ContainsAny(name, "~*") {
+					continue
+				}
+				hosts = append(hosts, strings.ToLower(strings.TrimPrefix(name, ".")))
+			}
+		}
+	}
+	return hosts
+}
+
+func (n *Nginx) Validate(ctx context.Context) error {
+	args := make([]string, len(n.TestCommand))
+	for i, a := range n.TestCommand {
+		args[i] = strings.ReplaceAll(a, "{config}", n.Config)
+	}
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		if msg := bytes.TrimSpace(out); len(msg) > 0 {
+			return fmt.Errorf("nginx config test failed: %s", msg)
+		}
+		return fmt.Errorf("nginx config test failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package render
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeNginx stands in for nginx -t, see examples/nginx.
+var fakeNginx = []string{"../../examples/nginx/fake-nginx.sh", "-t", "-c", "{config}"}
+
+// nginxLayout writes an nginx.conf including one site, whose ModSecurity
+// entry file includes the site's rule files, and returns the driver's
+// options and the paths of the site and rule files.
+func nginxLayout(t *testing.T) (o NginxOptions, site, rule string) {
+	t.Helper()
+	dir := t.TempDir()
+	o = NginxOptions{
+		Config:      filepath.Join(dir, "nginx.conf"),
+		SitesDir:    filepath.Join(dir, "sites"),
+		RulesRoot:   filepath.Join(dir, "modsecurity"),
+		TestCommand: fakeNginx,
+	}
+	site = filepath.Join(o.SitesDir, "example.conf")
+	main := filepath.Join(o.RulesRoot, "example", "main.conf")
+	rule = filepath.Join(o.RulesRoot, "example", "rules", "10.conf")
+	writeFile(t, o.Config, "events {}\n\nhttp {\n  include "+o.SitesDir+"/*.conf;\n}\n")
+	writeFile(t, site, "server {\n  server_name example.com;\n  modsecurity_rules_file "+main+";\n}\n")
+	writeFile(t, main, "SecRuleEngine On\nInclude "+filepath.Dir(rule)+"/*.conf\n")
+	writeFile(t, rule, "SecRule ARGS \"@rx attack\" \"id:1000,phase:2,deny\"\n")
+	return o, site, rule
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNginxValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, o *NginxOptions, site, rule string)
+		err   string
+	}{
+		{name: "valid"},
+		{
+			name: "broken rule file",
+			setup: func(t *testing.T, _ *NginxOptions, _, rule string) {
+				writeFile(t, rule, "BROKEN\n")
+			},
+			err: "nginx config test failed: nginx: [emerg] invalid directive in ",
+		},
+		{
+			name: "unbalanced site",
+			setup: func(t *testing.T, _ *NginxOptions, site, _ string) {
+				writeFile(t, site, "server {\n  server_name example.com;\n")
+			},
+			err: `unexpected end of file, expecting "}"`,
+		},
+		{
+			name: "missing rules entry file",
+			setup: func(t *testing.T, _ *NginxOptions, site, _ string) {
+				writeFile(t, site, "server {\n  modsecurity_rules_file /nonexistent/main.conf;\n}\n")
+			},
+			err: `open() "/nonexistent/main.conf" failed`,
+		},
+		{
+			name: "failure without output",
+			setup: func(t *testing.T, o *NginxOptions, _, _ string) {
+				o.TestCommand = []string{"false"}
+			},
+			err: "nginx config test failed: exit status 1",
+		},
+		{
+			name: "missing command",
+			setup: func(t *testing.T, o *NginxOptions, _, _ string) {
+				o.TestCommand = []string{filepath.Join(t.TempDir(), "nginx"), "-t"}
+			},
+			err: "nginx config test failed: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, site, rule := nginxLayout(t)
+			if tt.setup != nil {
+				tt.setup(t, &o, site, rule)
+			}
+			err := NewNginx(o).Validate(context.Background())
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("Validate: %v", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("Validate succeeded, want error containing %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("Validate: %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNginxSiteHosts(t *testing.T) {
+	src := "server {\n  server_name Example.com .www.example.com *.example.org ~^re _; # server_name other.com;\n  listen 80; server_name api.example.com;\n}\n"
+	want := []string{"example.com", "www.example.com", "api.example.com"}
+	if got := NewNginx(NginxOptions{}).SiteHosts([]byte(src)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("SiteHosts = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,141 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SiteRouter is implemented by drivers that can render the Caddy JSON
+// config with every site's route addressable by its "@id", so that a
+// single site can be updated through /id/<RouteID(site)>.
+type SiteRouter interface {
+	// Config returns the full JSON config with site routes tagged.
+	Config(ctx context.Context) ([]byte, error)
+	// SiteRoute returns the tagged route of site, or nil if the site does
+	// not exist. Sites that do not map to exactly one route are an error.
+	SiteRoute(ctx context.Context, site string) ([]byte, error)
+}
+
+// RouteID is the "@id" of a site's route.
+func RouteID(site string) string { return "waf-admin-site-" + site }
+
+// Config adapts the Caddyfile and tags each route whose hosts all belong
+// to one site, if that site has no other route.
+func (c *CaddyCoraza) Config(ctx context.Context) ([]byte, error) {
+	body, err := os.ReadFile(c.Caddyfile)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.adapt(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	hostSite := map[string]string{}
+	ents, err := os.ReadDir(c.SitesDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range ents {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), c.SiteExtension()) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(c.SitesDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range c.SiteHosts(b) {
+			hostSite[h] = strings.TrimSuffix(e.Name(), c.SiteExtension())
+		}
+	}
+	return tagRoutes(res.Result, hostSite)
+}
+
+func (c *CaddyCoraza) SiteRoute(ctx context.Context, site string) ([]byte, error) {
+	return siteRoute(ctx, c, filepath.Join(c.SitesDir, site+c.SiteExtension()), site)
+}
+
+func (c *CaddyJSON) SiteRoute(ctx context.Context, site string) ([]byte, error) {
+	return siteRoute(ctx, c, filepath.Join(c.SitesDir, site+c.SiteExtension()), site)
+}
+
+func siteRoute(ctx context.Context, sr SiteRouter, path, site string) ([]byte, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	cfg, err := sr.Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var root any
+	if err := json.Unmarshal(cfg, &root); err != nil {
+		return nil, err
+	}
+	for _, route := range serverRoutes(root) {
+		if route["@id"] == RouteID(site) {
+			return json.Marshal(route)
+		}
+	}
+	return nil, fmt.Errorf("site %s does not map to a single route", site)
+}
+
+// tagRoutes sets "@id" on the routes of cfg that belong to exactly one
+// site by their host matchers, unless they carry an id already.
+func tagRoutes(cfg []byte, hostSite map[string]string) ([]byte, error) {
+	var root any
+	if err := json.Unmarshal(cfg, &root); err != nil {
+		return nil, err
+	}
+	count := map[string]int{}
+	found := map[string]map[string]any{}
+	for _, route := range serverRoutes(root) {
+		if site := routeSite(route, hostSite); site != "" {
+			count[site]++
+			found[site] = route
+		}
+	}
+	for site, route := range found {
+		if _, ok := route["@id"]; !ok && count[site] == 1 {
+			route["@id"] = RouteID(site)
+		}
+	}
+	return json.Marshal(root)
+}
+
+// serverRoutes returns the top-level routes of every HTTP server.
+func serverRoutes(root any) []map[string]any {
+	var out []map[string]any
+	servers, _ := lookup(root, "apps", "http", "servers").(map[string]any)
+	for _, srv := range servers {
+		routes, _ := lookup(srv, "routes").([]any)
+		for _, r := range routes {
+			if m, ok := r.(map[string]any); ok {
+				out = append(out, m)
+			}
+		}
+	}
+	return out
+}
+
+// routeSite returns the site all host matchers of route belong to, or ""
+// if they name no host or several sites.
+func routeSite(route map[string]any, hostSite map[string]string) string {
+	site := ""
+	matches, _ := route["match"].([]any)
+	for _, m := range matches {
+		hosts, _ := lookup(m, "host").([]any)
+		for _, h := range hosts {
+			hs, _ := h.(string)
+			s, ok := hostSite[strings.ToLower(hs)]
+			if !ok || (site != "" && s != site) {
+				return ""
+			}
+			site = s
+		}
+	}
+	return site
+}
@@ -0,0 +1,123 @@
+package render
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// scratch mirrors a live Caddy/Coraza layout under dir for dry runs.
+type scratch struct {
+	live      CaddyOptions
+	sitesDir  string
+	rulesRoot string
+	rewrite   *strings.Replacer
+	restore   *strings.Replacer
+}
+
+func newScratch(live CaddyOptions, dir string) *scratch {
+	sc := &scratch{
+		live:      live,
+		sitesDir:  filepath.Join(dir, "sites"),
+		rulesRoot: filepath.Join(dir, "rules"),
+	}
+	sc.rewrite = longestFirst(live.SitesDir, sc.sitesDir, live.RulesRoot, sc.rulesRoot)
+	sc.restore = longestFirst(sc.sitesDir, live.SitesDir, sc.rulesRoot, live.RulesRoot)
+	return sc
+}
+
+// longestFirst builds a replacer for two old/new pairs that prefers the
+// longer old string when one is a prefix of the other.
+func longestFirst(oldA, newA, oldB, newB string) *strings.Replacer {
+	if len(oldB) > len(oldA) {
+		oldA, newA, oldB, newB = oldB, newB, oldA, newA
+	}
+	return strings.NewReplacer(oldA, newA, oldB, newB)
+}
+
+func (sc *scratch) unrewrite(s string) string { return sc.restore.Replace(s) }
+
+// build copies the live site snippets and rule tree with overlay applied
+// and returns the rewritten Caddyfile.
+func (sc *scratch) build(overlay map[string][]byte) ([]byte, error) {
+	files := map[string][]byte{}
+	if err := collect(sc.live.SitesDir, false, sc.live.ScratchDir, files); err != nil {
+		return nil, err
+	}
+	if err := collect(sc.live.RulesRoot, true, sc.live.ScratchDir, files); err != nil {
+		return nil, err
+	}
+	caddyfile, err := os.ReadFile(sc.live.Caddyfile)
+	if err != nil {
+		return nil, err
+	}
+	for path, data := range overlay {
+		if path == sc.live.Caddyfile {
+			caddyfile = data
+			continue
+		}
+		if data == nil {
+			delete(files, path)
+		} else {
+			files[path] = data
+		}
+	}
+
+	for path, data := range files {
+		dest, ok := sc.mapPath(path)
+		if !ok {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(dest, []byte(sc.rewrite.Replace(string(data))), 0o644); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(sc.rewrite.Replace(string(caddyfile))), nil
+}
+
+func (sc *scratch) mapPath(path string) (string, bool) {
+	pairs := [][2]string{{sc.live.RulesRoot, sc.rulesRoot}, {sc.live.SitesDir, sc.sitesDir}}
+	if len(sc.live.SitesDir) > len(sc.live.RulesRoot) {
+		pairs[0], pairs[1] = pairs[1], pairs[0]
+	}
+	for _, m := range pairs {
+		rel, err := filepath.Rel(m[0], path)
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.Join(m[1], rel), true
+		}
+	}
+	return "", false
+}
+
+// collect reads the regular files under dir into files, descending into
+// subdirectories only when recursive is set. skip and hidden directories
+// are left out.
+func collect(dir string, recursive bool, skip string, files map[string][]byte) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && (!recursive || p == skip || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[p] = b
+		return nil
+	})
+}
@@ -0,0 +1,168 @@
+package revision
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Stack-Dash/waf-admin/internal/util"
+)
+
+var ErrNotFound = errors.New("revision not found")
+
+// Revision is one recorded state of a managed file. Content is only
+// populated by Get; List returns metadata only.
+type Revision struct {
+	Number     int       `json:"number"`
+	Time       time.Time `json:"time"`
+	Author     string    `json:"author"`
+	Op         string    `json:"op"`
+	Hash       string    `json:"hash,omitempty"`
+	Size       int       `json:"size"`
+	Deleted    bool      `json:"deleted,omitempty"`
+	RollbackOf int       `json:"rollbackOf,omitempty"`
+	Changeset  string    `json:"changeset,omitempty"`
+	Content    string    `json:"content,omitempty"`
+}
+
+// Store keeps numbered revisions on disk under <dir>/<key>/<number>.json.
+// Keys are slash separated resource identifiers such as "sites/example"
+// or "rules/example/10-custom.conf".
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewStore(dir string) *Store { return &Store{dir: dir} }
+
+// Hash returns the content hash recorded with revisions.
+func Hash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Record appends rev to the history of key, assigning its number, time
+// and hash.
+func (s *Store) Record(key string, rev Revision) (Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nums, err := s.numbers(key)
+	if err != nil {
+		return Revision{}, err
+	}
+	rev.Number = 1
+	if len(nums) > 0 {
+		rev.Number = nums[len(nums)-1] + 1
+	}
+	if rev.Time.IsZero() {
+		rev.Time = time.Now().UTC()
+	}
+	if rev.Deleted {
+		rev.Hash, rev.Size, rev.Content = "", 0, ""
+	} else {
+		rev.Hash = Hash([]byte(rev.Content))
+		rev.Size = len(rev.Content)
+	}
+
+	dir := s.keyDir(key)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Revision{}, err
+	}
+	b, err := json.MarshalIndent(rev, "", "  ")
+	if err != nil {
+		return Revision{}, err
+	}
+	if err := util.AtomicWrite(filepath.Join(dir, fmt.Sprintf("%06d.json", rev.Number)), b, 0o644); err != nil {
+		return Revision{}, err
+	}
+	return rev, nil
+}
+
+// Empty reports whether key has no recorded revisions yet.
+func (s *Store) Empty(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nums, err := s.numbers(key)
+	return len(nums) == 0, err
+}
+
+// List returns the revisions of key, oldest first, without content.
+func (s *Store) List(key string) ([]Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nums, err := s.numbers(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Revision, 0, len(nums))
+	for _, n := range nums {
+		rev, err := s.read(key, n)
+		if err != nil {
+			return nil, err
+		}
+		rev.Content = ""
+		out = append(out, rev)
+	}
+	return out, nil
+}
+
+// Get returns revision n of key including its content.
+func (s *Store) Get(key string, n int) (Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(key, n)
+}
+
+func (s *Store) read(key string, n int) (Revision, error) {
+	b, err := os.ReadFile(filepath.Join(s.keyDir(key), fmt.Sprintf("%06d.json", n)))
+	if errors.Is(err, os.ErrNotExist) {
+		return Revision{}, ErrNotFound
+	}
+	if err != nil {
+		return Revision{}, err
+	}
+	var rev Revision
+	if err := json.Unmarshal(b, &rev); err != nil {
+		return Revision{}, err
+	}
+	return rev, nil
+}
+
+func (s *Store) numbers(key string) ([]int, error) {
+	ents, err := os.ReadDir(s.keyDir(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var nums []int
+	for _, e := range ents {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	return nums, nil
+}
+
+func (s *Store) keyDir(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
@@ -19,6 +19,7 @@ import (
 type Scheduler struct{ jobs []job }
 type job struct {
 	name, at string
+	every    time.Duration
 	f        func(context.Context) error
 	stop     chan struct{}
 }
@@ -30,13 +31,24 @@ func (s *Scheduler) AddDaily(name, atHHMM string, f func(context.Context) error)
 	return nil
 }
 
+// AddEvery runs f repeatedly with the given interval between runs.
+func (s *Scheduler) AddEvery(name string, every time.Duration, f func(context.Context) error) error {
+	if every <= 0 {
+		return fmt.Errorf("%s: interval must be positive", name)
+	}
+	s.jobs = append(s.jobs, job{name: name, every: every, f: f, stop: make(chan struct{})})
+	return nil
+}
+
 func (s *Scheduler) Start() {
 	for i := range s.jobs {
 		j := s.jobs[i]
 		go func() {
 			for {
-				next := nextAt(j.at)
-				d := time.Until(next)
+				d := j.every
+				if d == 0 {
+					d = time.Until(nextAt(j.at))
+				}
 				select {
 				case <-time.After(d):
 					_ = j.f(context.Background())
@@ -73,7 +85,7 @@ func RunBackup(ctx context.Context, b api.BackupConfig, c api.CaddyConfig) error
 	ts := time.Now().UTC().Format("20060102-150405")
 	buf := new(bytes.Buffer)
 	zw := zip.NewWriter(buf)
-	for _, p := range []string{c.Caddyfile, c.SitesDir, c.RulesRoot} {
+	for _, p := range []string{c.Caddyfile, c.BaseConfig, c.SitesDir, c.RulesRoot} {
 		_ = addToZip(zw, p)
 	}
 	_ = zw.Close()
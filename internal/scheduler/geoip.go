@@ -12,16 +12,14 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/Stack-Dash/waf-admin/internal/api"
-	"github.com/Stack-Dash/waf-admin/internal/reload"
 )
 
-const geoIPFileName = "GeoLite2-Country.mmdb"
-
 // RunGeoIPUpdate downloads the latest GeoLite2-Country database, writes it
 // atomically to the configured directory, then stops Caddy so Docker's
-// restart policy brings it back with the fresh database loaded.
-func RunGeoIPUpdate(ctx context.Context, cfg api.GeoIPConfig, caddy *reload.CaddyAdmin) error {
-	dest := filepath.Join(cfg.DatabaseDir, geoIPFileName)
+// restart policy brings it back with the fresh database loaded. caddy is a
+// reload.CaddyAdmin or, with several instances, a reload.FanOut.
+func RunGeoIPUpdate(ctx context.Context, cfg api.GeoIPConfig, caddy interface{ Stop(context.Context) error }) error {
+	dest := cfg.DatabasePath()
 
 	if err := downloadFile(ctx, cfg.DatabaseURL, dest); err != nil {
 		log.Error().Err(err).Msg("geoip update: download failed")
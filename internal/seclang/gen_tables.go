@@ -0,0 +1,92 @@
+//go:build ignore
+
+// gen_tables writes tables.gen.go from the registries of the Coraza
+// version in go.mod, which are internal to Coraza and cannot be imported.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+func main() {
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "github.com/corazawaf/coraza/v3").Output()
+	if err != nil {
+		log.Fatalf("locate coraza: %v", err)
+	}
+	root := filepath.Join(strings.TrimSpace(string(out)), "internal")
+
+	tables := []struct {
+		name, doc string
+		names     []string
+	}{
+		{"directives", "directives are the keys of Coraza's directive map, plus Include, which its parser handles itself.",
+			append(scan(root, "seclang/directivesmap.gen.go", `(?m)^\t"(\w+)":\s+directive`), "include")},
+		{"operators", "operators are registered in internal/operators.",
+			scan(root, "operators/*.go", `Register\("(\w+)"`)},
+		{"actions", "actions are registered in internal/actions.",
+			scan(root, "actions/actions.go", `Register\("(\w+)"`)},
+		{"ctlOptions", "ctlOptions are the options the ctl action parses.",
+			scan(root, "actions/ctl.go", `case "(\w+)":\s+act = ctl`)},
+		{"transformations", "transformations are registered in internal/transformations.",
+			scan(root, "transformations/*.go", `Register\("(\w+)"`)},
+	}
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by gen_tables.go from the Coraza registries. DO NOT EDIT.\n\npackage seclang\n\nvar (\n")
+	for _, t := range tables {
+		fmt.Fprintf(&b, "\t// %s\n\t%s = set(\n", t.doc, t.name)
+		for _, n := range t.names {
+			fmt.Fprintf(&b, "\t\t%q,\n", n)
+		}
+		b.WriteString("\t)\n\n")
+	}
+	b.WriteString(")\n")
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("tables.gen.go", src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// scan returns the sorted, lower-cased, distinct first submatches of expr
+// in the non-test files matching glob under root.
+func scan(root, glob, expr string) []string {
+	files, err := filepath.Glob(filepath.Join(root, glob))
+	if err != nil || len(files) == 0 {
+		log.Fatalf("no files match %s", glob)
+	}
+	re := regexp.MustCompile(expr)
+	seen := map[string]bool{}
+	for _, f := range files {
+		if strings.HasSuffix(f, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range re.FindAllStringSubmatch(string(src), -1) {
+			seen[strings.ToLower(m[1])] = true
+		}
+	}
+	if len(seen) == 0 {
+		log.Fatalf("nothing found in %s", glob)
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,210 @@
+package seclang
+
+//go:generate go run gen_tables.go
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/corazawaf/coraza/v3/types/variables"
+)
+
+// Lint parses src and checks it against the directives, variables,
+// operators, actions and transformations of the Coraza version waf-admin
+// is built with (see tables.gen.go). Issues are sorted by position.
+func Lint(src string) []Issue {
+	dirs, issues := Parse(src)
+	seen := map[int]Pos{}
+	for _, d := range dirs {
+		name := strings.ToLower(d.Name)
+		if _, ok := directives[name]; !ok {
+			issues = append(issues, errorAt(d.Pos, fmt.Sprintf("unknown directive %q", d.Name)))
+			continue
+		}
+		if d.Rule != nil {
+			issues = append(issues, lintRule(d, seen)...)
+			continue
+		}
+		issues = append(issues, lintDirective(d)...)
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Line != issues[j].Line {
+			return issues[i].Line < issues[j].Line
+		}
+		return issues[i].Col < issues[j].Col
+	})
+	return issues
+}
+
+// HasErrors reports whether any issue has error severity.
+func HasErrors(issues []Issue) bool {
+	for _, i := range issues {
+		if i.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func lintRule(d Directive, seen map[int]Pos) []Issue {
+	var issues []Issue
+	r := d.Rule
+
+	for _, v := range r.Variables {
+		if _, err := variables.Parse(v.Name); err != nil {
+			issues = append(issues, errorAt(v.Pos, fmt.Sprintf("unknown variable %q", v.Name)))
+		}
+	}
+	if strings.EqualFold(d.Name, "SecRule") {
+		if _, ok := operators[strings.ToLower(r.Operator.Name)]; !ok {
+			issues = append(issues, errorAt(r.Operator.Pos, fmt.Sprintf("unknown operator @%s", r.Operator.Name)))
+		}
+	}
+
+	for _, a := range r.Actions {
+		issues = append(issues, lintAction(a)...)
+	}
+
+	id, hasID := r.ID()
+	_, hasIDAction := r.Action("id")
+	switch {
+	case r.Chained:
+		if hasIDAction {
+			issues = append(issues, errorAt(d.Pos, "chained rule must not have an id"))
+		}
+		if r.HasAction("phase") {
+			issues = append(issues, warningAt(d.Pos, "phase is ignored on chained rules"))
+		}
+	case !hasIDAction:
+		issues = append(issues, errorAt(d.Pos, "rule is missing an id action"))
+	case !hasID || id <= 0:
+		a, _ := r.Action("id")
+		issues = append(issues, errorAt(a.Pos, "id must be a positive integer"))
+	default:
+		if prev, dup := seen[id]; dup {
+			issues = append(issues, errorAt(d.Pos, fmt.Sprintf("duplicate rule id %d (first used on line %d)", id, prev.Line)))
+		} else {
+			seen[id] = d.Pos
+		}
+		if !r.HasAction("phase") {
+			issues = append(issues, warningAt(d.Pos, "rule has no phase action, defaulting to phase 2"))
+		}
+	}
+	return issues
+}
+
+func lintAction(a Action) []Issue {
+	name := strings.ToLower(a.Name)
+	if _, ok := actions[name]; !ok {
+		return []Issue{errorAt(a.Pos, fmt.Sprintf("unknown action %q", a.Name))}
+	}
+	switch name {
+	case "phase":
+		switch strings.ToLower(a.Param) {
+		case "1", "2", "3", "4", "5", "request", "response", "logging":
+		default:
+			return []Issue{errorAt(a.Pos, fmt.Sprintf("invalid phase %q", a.Param))}
+		}
+	case "severity":
+		if n, err := strconv.Atoi(a.Param); err == nil {
+			if n < 0 || n > 7 {
+				return []Issue{errorAt(a.Pos, "severity must be between 0 and 7")}
+			}
+		} else if _, ok := severities[strings.ToUpper(a.Param)]; !ok {
+			return []Issue{errorAt(a.Pos, fmt.Sprintf("invalid severity %q", a.Param))}
+		}
+	case "status":
+		if n, err := strconv.Atoi(a.Param); err != nil || n < 100 || n > 599 {
+			return []Issue{errorAt(a.Pos, fmt.Sprintf("invalid status %q", a.Param))}
+		}
+	case "ctl":
+		opt, _, ok := strings.Cut(a.Param, "=")
+		if !ok {
+			return []Issue{errorAt(a.Pos, "ctl expects option=value")}
+		}
+		if _, known := ctlOptions[strings.ToLower(opt)]; !known {
+			return []Issue{errorAt(a.Pos, fmt.Sprintf("unknown ctl option %q", opt))}
+		}
+	case "t":
+		if _, ok := transformations[strings.ToLower(a.Param)]; !ok {
+			return []Issue{errorAt(a.Pos, fmt.Sprintf("unknown transformation %q", a.Param))}
+		}
+	}
+	if _, needs := actionsWithParam[name]; needs && a.Param == "" {
+		return []Issue{errorAt(a.Pos, fmt.Sprintf("action %s requires a parameter", a.Name))}
+	}
+	return nil
+}
+
+func lintDirective(d Directive) []Issue {
+	name := strings.ToLower(d.Name)
+	switch name {
+	case "secmarker", "include", "secruleengine", "secdefaultaction":
+		if len(d.Args) != 1 {
+			return []Issue{errorAt(d.Pos, fmt.Sprintf("%s expects exactly one argument", d.Name))}
+		}
+	case "secruleremovebyid", "secruleremovebytag", "secruleremovebymsg":
+		if len(d.Args) == 0 {
+			return []Issue{errorAt(d.Pos, fmt.Sprintf("%s expects at least one argument", d.Name))}
+		}
+	case "secruleupdatetargetbyid", "secruleupdatetargetbytag", "secruleupdatetargetbymsg", "secruleupdateactionbyid":
+		if len(d.Args) < 2 {
+			return []Issue{errorAt(d.Pos, fmt.Sprintf("%s expects a rule selector and a value", d.Name))}
+		}
+	}
+	switch name {
+	case "secruleengine":
+		switch strings.ToLower(d.Args[0].Value) {
+		case "on", "off", "detectiononly":
+		default:
+			return []Issue{errorAt(d.Args[0].Pos, "SecRuleEngine must be On, Off or DetectionOnly")}
+		}
+	case "secruleremovebyid":
+		for _, a := range d.Args {
+			for _, part := range strings.Fields(a.Value) {
+				lo, hi, isRange := strings.Cut(part, "-")
+				if _, err := strconv.Atoi(lo); err != nil {
+					return []Issue{errorAt(a.Pos, fmt.Sprintf("invalid rule id %q", part))}
+				}
+				if _, err := strconv.Atoi(hi); isRange && err != nil {
+					return []Issue{errorAt(a.Pos, fmt.Sprintf("invalid rule id range %q", part))}
+				}
+			}
+		}
+	case "secruleupdatetargetbyid":
+		if _, err := strconv.Atoi(d.Args[0].Value); err != nil {
+			return []Issue{errorAt(d.Args[0].Pos, fmt.Sprintf("invalid rule id %q", d.Args[0].Value))}
+		}
+		vars, issues := parseVariables(d.Args[1])
+		for _, v := range vars {
+			if _, err := variables.Parse(v.Name); err != nil {
+				issues = append(issues, errorAt(v.Pos, fmt.Sprintf("unknown variable %q", v.Name)))
+			}
+		}
+		return issues
+	}
+	return nil
+}
+
+func set(names ...string) map[string]struct{} {
+	m := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		m[n] = struct{}{}
+	}
+	return m
+}
+
+// Names are stored lower case (directives, operators, actions, ctl
+// options, transformations) or upper case (severities). The tables of
+// Coraza's names are generated into tables.gen.go.
+var (
+	actionsWithParam = set(
+		"ctl", "exec", "expirevar", "id", "initcol", "logdata", "maturity", "msg", "phase",
+		"redirect", "rev", "setenv", "setvar", "severity", "skip", "skipafter", "status", "t",
+		"tag", "ver",
+	)
+
+	severities = set("EMERGENCY", "ALERT", "CRITICAL", "ERROR", "WARNING", "NOTICE", "INFO", "DEBUG")
+)
@@ -0,0 +1,371 @@
+package seclang
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Issue is a problem found while parsing or linting, positioned at a
+// 1-based line and column of the source.
+type Issue struct {
+	Line     int    `json:"line"`
+	Col      int    `json:"col"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s", i.Line, i.Col, i.Severity, i.Message)
+}
+
+// Pos is a 1-based source position.
+type Pos struct{ Line, Col int }
+
+// Arg is one directive argument. Raw is the text as written (without
+// surrounding quotes), Value has escaped quotes resolved.
+type Arg struct {
+	Value  string
+	Raw    string
+	Quoted bool
+	Pos    Pos
+
+	line *logicalLine
+	off  int // offset of Raw within line
+}
+
+// posAt returns the source position of byte i of Raw.
+func (a Arg) posAt(i int) Pos {
+	if a.line == nil || a.off+i >= len(a.line.pos) {
+		return a.Pos
+	}
+	return a.line.pos[a.off+i]
+}
+
+// Directive is one configuration statement, possibly spanning several
+// physical lines joined with a trailing backslash.
+type Directive struct {
+	Name string
+	Args []Arg
+	Pos  Pos
+
+	// Rule is set for SecRule and SecAction.
+	Rule *Rule
+}
+
+// Rule is the parsed form of a SecRule or SecAction.
+type Rule struct {
+	Variables []Variable
+	Operator  Operator
+	Actions   []Action
+	// Chained is set when the rule continues a chain started by the
+	// previous rule.
+	Chained bool
+}
+
+type Variable struct {
+	Name    string
+	Key     string
+	Negated bool
+	Count   bool
+	Pos     Pos
+}
+
+type Operator struct {
+	Name    string
+	Param   string
+	Negated bool
+	Pos     Pos
+}
+
+type Action struct {
+	Name  string
+	Param string
+	Pos   Pos
+}
+
+// ID returns the value of the rule's id action.
+func (r *Rule) ID() (int, bool) {
+	for _, a := range r.Actions {
+		if a.Name == "id" {
+			n, err := strconv.Atoi(a.Param)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// Action returns the first action called name.
+func (r *Rule) Action(name string) (Action, bool) {
+	for _, a := range r.Actions {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Action{}, false
+}
+
+// HasAction reports whether the rule carries an action called name.
+func (r *Rule) HasAction(name string) bool {
+	_, ok := r.Action(name)
+	return ok
+}
+
+type logicalLine struct {
+	text []byte
+	pos  []Pos
+}
+
+// Parse splits src into directives and parses SecRule/SecAction
+// arguments. Syntax errors are returned as issues; directives that could
+// not be parsed are skipped.
+func Parse(src string) ([]Directive, []Issue) {
+	var (
+		dirs    []Directive
+		issues  []Issue
+		inChain bool
+	)
+	for _, ll := range logicalLines(src) {
+		d, errs := parseDirective(ll)
+		issues = append(issues, errs...)
+		if d == nil {
+			continue
+		}
+		switch strings.ToLower(d.Name) {
+		case "secrule":
+			if len(d.Args) < 2 || len(d.Args) > 3 {
+				issues = append(issues, errorAt(d.Pos, "SecRule expects variables, operator and optional actions"))
+				inChain = false
+				continue
+			}
+			r := &Rule{Chained: inChain}
+			r.Variables, errs = parseVariables(d.Args[0])
+			issues = append(issues, errs...)
+			r.Operator = parseOperator(d.Args[1])
+			if len(d.Args) == 3 {
+				r.Actions, errs = parseActions(d.Args[2])
+				issues = append(issues, errs...)
+			}
+			d.Rule = r
+			inChain = r.HasAction("chain")
+		case "secaction":
+			if len(d.Args) != 1 {
+				issues = append(issues, errorAt(d.Pos, "SecAction expects exactly one argument"))
+				inChain = false
+				continue
+			}
+			r := &Rule{Chained: inChain}
+			r.Actions, errs = parseActions(d.Args[0])
+			issues = append(issues, errs...)
+			d.Rule = r
+			inChain = false
+		default:
+			if inChain {
+				issues = append(issues, errorAt(d.Pos, "chained rule expected after rule with chain action"))
+			}
+			inChain = false
+		}
+		dirs = append(dirs, *d)
+	}
+	if inChain && len(dirs) > 0 {
+		issues = append(issues, errorAt(dirs[len(dirs)-1].Pos, "rule has chain action but no rule follows"))
+	}
+	return dirs, issues
+}
+
+// logicalLines joins backslash-continued lines and drops comments and
+// blank lines, keeping the source position of every byte.
+func logicalLines(src string) []*logicalLine {
+	var (
+		out []*logicalLine
+		cur *logicalLine
+	)
+	for i, line := range strings.Split(src, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if cur == nil && (trimmed == "" || strings.HasPrefix(trimmed, "#")) {
+			continue
+		}
+		if cur == nil {
+			cur = &logicalLine{}
+		}
+		body := strings.TrimRight(line, " \t")
+		cont := strings.HasSuffix(body, "\\")
+		if cont {
+			body = body[:len(body)-1]
+		}
+		for j := 0; j < len(body); j++ {
+			cur.text = append(cur.text, body[j])
+			cur.pos = append(cur.pos, Pos{Line: i + 1, Col: j + 1})
+		}
+		if cont {
+			continue
+		}
+		out = append(out, cur)
+		cur = nil
+	}
+	if cur != nil && len(cur.text) > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+func parseDirective(ll *logicalLine) (*Directive, []Issue) {
+	var (
+		args   []Arg
+		issues []Issue
+	)
+	t := ll.text
+	for i := 0; i < len(t); {
+		if t[i] == ' ' || t[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		if t[i] == '"' {
+			i++
+			for i < len(t) && t[i] != '"' {
+				if t[i] == '\\' && i+1 < len(t) {
+					i++
+				}
+				i++
+			}
+			if i >= len(t) {
+				issues = append(issues, errorAt(ll.pos[start], "unterminated quoted string"))
+				return nil, issues
+			}
+			raw := string(t[start+1 : i])
+			args = append(args, Arg{Value: unescape(raw), Raw: raw, Quoted: true, Pos: ll.pos[start], line: ll, off: start + 1})
+			i++
+			if i < len(t) && t[i] != ' ' && t[i] != '\t' {
+				issues = append(issues, errorAt(ll.pos[i], "missing space after quoted argument"))
+			}
+			continue
+		}
+		for i < len(t) && t[i] != ' ' && t[i] != '\t' {
+			i++
+		}
+		raw := string(t[start:i])
+		args = append(args, Arg{Value: raw, Raw: raw, Pos: ll.pos[start], line: ll, off: start})
+	}
+	if len(args) == 0 {
+		return nil, issues
+	}
+	return &Directive{Name: args[0].Value, Args: args[1:], Pos: args[0].Pos}, issues
+}
+
+func unescape(s string) string { return strings.ReplaceAll(s, `\"`, `"`) }
+
+// parseVariables splits a variable list such as
+// "ARGS|!ARGS:foo|&REQUEST_HEADERS:/^x-/" into its members.
+func parseVariables(a Arg) ([]Variable, []Issue) {
+	var (
+		vars   []Variable
+		issues []Issue
+	)
+	raw := a.Raw
+	start := 0
+	inRegex := false
+	for i := 0; i <= len(raw); i++ {
+		if i < len(raw) {
+			c := raw[i]
+			if c == '/' && i > 0 && (raw[i-1] == ':' || inRegex) && (i == 0 || raw[i-1] != '\\') {
+				inRegex = !inRegex
+			}
+			if c != '|' || inRegex {
+				continue
+			}
+		}
+		part := strings.TrimSpace(raw[start:i])
+		if part == "" {
+			issues = append(issues, errorAt(a.posAt(start), "empty variable"))
+			start = i + 1
+			continue
+		}
+		v := Variable{Pos: a.posAt(start)}
+		if strings.HasPrefix(part, "!") {
+			v.Negated = true
+			part = part[1:]
+		} else if strings.HasPrefix(part, "&") {
+			v.Count = true
+			part = part[1:]
+		}
+		v.Name, v.Key, _ = strings.Cut(part, ":")
+		vars = append(vars, v)
+		start = i + 1
+	}
+	return vars, issues
+}
+
+func parseOperator(a Arg) Operator {
+	op := Operator{Pos: a.Pos}
+	v := strings.TrimLeft(a.Value, " ")
+	if strings.HasPrefix(v, "!") {
+		op.Negated = true
+		v = v[1:]
+	}
+	if !strings.HasPrefix(v, "@") {
+		op.Name, op.Param = "rx", v
+		return op
+	}
+	name, param, _ := strings.Cut(v[1:], " ")
+	op.Name, op.Param = name, param
+	return op
+}
+
+// parseActions splits an action list on commas outside single quotes.
+func parseActions(a Arg) ([]Action, []Issue) {
+	var (
+		acts   []Action
+		issues []Issue
+	)
+	raw := a.Raw
+	start := 0
+	quoted := false
+	for i := 0; i <= len(raw); i++ {
+		if i < len(raw) {
+			if raw[i] == '\'' && (i == 0 || raw[i-1] != '\\') {
+				quoted = !quoted
+			}
+			if raw[i] != ',' || quoted {
+				continue
+			}
+		}
+		part := raw[start:i]
+		lead := len(part) - len(strings.TrimLeft(part, " \t"))
+		part = strings.TrimSpace(part)
+		pos := a.posAt(start + lead)
+		start = i + 1
+		if part == "" {
+			if i < len(raw) || len(acts) > 0 {
+				issues = append(issues, errorAt(pos, "empty action"))
+			}
+			continue
+		}
+		name, param, _ := strings.Cut(part, ":")
+		param = strings.TrimSpace(param)
+		if len(param) >= 2 && param[0] == '\'' && param[len(param)-1] == '\'' {
+			param = param[1 : len(param)-1]
+		}
+		acts = append(acts, Action{Name: strings.TrimSpace(name), Param: unescape(param), Pos: pos})
+	}
+	if quoted {
+		// Coraza accepts this (coraza.conf-recommended has one), taking
+		// the rest of the actions as the quoted value
+		issues = append(issues, warningAt(a.Pos, "unterminated single quote in actions"))
+	}
+	return acts, issues
+}
+
+func errorAt(p Pos, msg string) Issue {
+	return Issue{Line: p.Line, Col: p.Col, Severity: SeverityError, Message: msg}
+}
+
+func warningAt(p Pos, msg string) Issue {
+	return Issue{Line: p.Line, Col: p.Col, Severity: SeverityWarning, Message: msg}
+}
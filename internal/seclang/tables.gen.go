@@ -0,0 +1,207 @@
+// Code generated by gen_tables.go from the Coraza registries. DO NOT EDIT.
+
+package seclang
+
+var (
+	// directives are the keys of Coraza's directive map, plus Include, which its parser handles itself.
+	directives = set(
+		"secaction",
+		"secargumentseparator",
+		"secargumentslimit",
+		"secauditengine",
+		"secauditlog",
+		"secauditlogdir",
+		"secauditlogdirmode",
+		"secauditlogfilemode",
+		"secauditlogformat",
+		"secauditlogparts",
+		"secauditlogrelevantstatus",
+		"secauditlogtype",
+		"seccollectiontimeout",
+		"seccomponentsignature",
+		"secconnengine",
+		"secconnreadstatelimit",
+		"secconnwritestatelimit",
+		"seccookieformat",
+		"secdatadir",
+		"secdataset",
+		"secdebuglog",
+		"secdebugloglevel",
+		"secdefaultaction",
+		"secgsblookupdb",
+		"sechashengine",
+		"sechashkey",
+		"sechashmethodpm",
+		"sechashmethodrx",
+		"sechashparam",
+		"sechttpblkey",
+		"secignorerulecompilationerrors",
+		"secmarker",
+		"secpcrematchlimit",
+		"secpcrematchlimitrecursion",
+		"secremoterules",
+		"secremoterulesfailaction",
+		"secrequestbodyaccess",
+		"secrequestbodyinmemorylimit",
+		"secrequestbodylimit",
+		"secrequestbodylimitaction",
+		"secrequestbodynofileslimit",
+		"secresponsebodyaccess",
+		"secresponsebodylimit",
+		"secresponsebodylimitaction",
+		"secresponsebodymimetype",
+		"secresponsebodymimetypesclear",
+		"secrule",
+		"secruleengine",
+		"secruleperftime",
+		"secruleremovebyid",
+		"secruleremovebymsg",
+		"secruleremovebytag",
+		"secrulescript",
+		"secruleupdateactionbyid",
+		"secruleupdatetargetbyid",
+		"secruleupdatetargetbymsg",
+		"secruleupdatetargetbytag",
+		"secsensorid",
+		"secserversignature",
+		"sectmpdir",
+		"secunicodemap",
+		"secuploaddir",
+		"secuploadfilelimit",
+		"secuploadfilemode",
+		"secuploadkeepfiles",
+		"secwebappid",
+		"include",
+	)
+
+	// operators are registered in internal/operators.
+	operators = set(
+		"beginswith",
+		"contains",
+		"detectsqli",
+		"detectxss",
+		"endswith",
+		"eq",
+		"ge",
+		"geolookup",
+		"gt",
+		"inspectfile",
+		"ipmatch",
+		"ipmatchfromdataset",
+		"ipmatchfromfile",
+		"le",
+		"lt",
+		"nomatch",
+		"pm",
+		"pmfromdataset",
+		"pmfromfile",
+		"rbl",
+		"restpath",
+		"rx",
+		"streq",
+		"unconditionalmatch",
+		"validatebyterange",
+		"validatenid",
+		"validateurlencoding",
+		"validateutf8encoding",
+		"within",
+	)
+
+	// actions are registered in internal/actions.
+	actions = set(
+		"allow",
+		"auditlog",
+		"block",
+		"capture",
+		"chain",
+		"ctl",
+		"deny",
+		"drop",
+		"exec",
+		"expirevar",
+		"id",
+		"initcol",
+		"log",
+		"logdata",
+		"maturity",
+		"msg",
+		"multimatch",
+		"noauditlog",
+		"nolog",
+		"pass",
+		"phase",
+		"redirect",
+		"rev",
+		"setenv",
+		"setvar",
+		"severity",
+		"skip",
+		"skipafter",
+		"status",
+		"t",
+		"tag",
+		"ver",
+	)
+
+	// ctlOptions are the options the ctl action parses.
+	ctlOptions = set(
+		"auditengine",
+		"auditlogparts",
+		"debugloglevel",
+		"forcerequestbodyvariable",
+		"forceresponsebodyvariable",
+		"hashenforcement",
+		"hashengine",
+		"requestbodyaccess",
+		"requestbodylimit",
+		"requestbodyprocessor",
+		"responsebodyaccess",
+		"responsebodylimit",
+		"responsebodyprocessor",
+		"ruleengine",
+		"ruleremovebyid",
+		"ruleremovebymsg",
+		"ruleremovebytag",
+		"ruleremovetargetbyid",
+		"ruleremovetargetbymsg",
+		"ruleremovetargetbytag",
+	)
+
+	// transformations are registered in internal/transformations.
+	transformations = set(
+		"base64decode",
+		"base64decodeext",
+		"base64encode",
+		"cmdline",
+		"compresswhitespace",
+		"cssdecode",
+		"escapeseqdecode",
+		"hexdecode",
+		"hexencode",
+		"htmlentitydecode",
+		"jsdecode",
+		"length",
+		"lowercase",
+		"md5",
+		"none",
+		"normalisepath",
+		"normalisepathwin",
+		"normalizepath",
+		"normalizepathwin",
+		"removecomments",
+		"removecommentschar",
+		"removenulls",
+		"removewhitespace",
+		"replacecomments",
+		"replacenulls",
+		"sha1",
+		"trim",
+		"trimleft",
+		"trimright",
+		"uppercase",
+		"urldecode",
+		"urldecodeuni",
+		"urlencode",
+		"utf8tounicode",
+	)
+)
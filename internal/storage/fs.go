@@ -26,6 +26,13 @@ func (FS) List(_ context.Context, dir string) ([]fs.DirEntry, error) { return os
 
 func (FS) Delete(_ context.Context, path string) error { return os.Remove(path) }
 
+func (FS) Rename(_ context.Context, from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
 func (FS) MkdirAll(_ context.Context, dir string, mode fs.FileMode) error {
 	return os.MkdirAll(dir, mode)
 }
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Stack-Dash/waf-admin/internal/util"
+)
+
+type GitOptions struct {
+	Dir         string
+	AuthorName  string
+	AuthorEmail string
+}
+
+// Git stores files like FS but commits every WriteAtomic, Delete and
+// Rename. The commit message is taken from the context (see WithMessage).
+// Paths outside the working tree at Dir are rejected before anything is
+// written; when the commit fails the change stays on disk and a
+// *CommitError is returned.
+type Git struct {
+	GitOptions
+	mu sync.Mutex
+}
+
+// CommitError reports a change that was made on disk but not committed.
+type CommitError struct{ Err error }
+
+func (e *CommitError) Error() string { return "git storage: change not committed: " + e.Err.Error() }
+func (e *CommitError) Unwrap() error { return e.Err }
+
+// NewGit opens the working tree at o.Dir, initialising a repository there
+// if none exists yet.
+func NewGit(o GitOptions) (*Git, error) {
+	if o.Dir == "" {
+		return nil, errors.New("git storage needs a directory")
+	}
+	if o.AuthorName == "" {
+		o.AuthorName = "waf-admin"
+	}
+	if o.AuthorEmail == "" {
+		o.AuthorEmail = "waf-admin@localhost"
+	}
+	dir, err := filepath.Abs(o.Dir)
+	if err != nil {
+		return nil, err
+	}
+	o.Dir = dir
+	g := &Git{GitOptions: o}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		if _, err := g.git(context.Background(), "init", "-q"); err != nil {
+			return nil, err
+		}
+		log.Info().Str("dir", dir).Msg("initialised git storage")
+	}
+	return g, nil
+}
+
+func (g *Git) Read(_ context.Context, path string) ([]byte, error) { return os.ReadFile(path) }
+
+func (g *Git) WriteAtomic(ctx context.Context, path string, data []byte, mode fs.FileMode) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.inside(path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := util.AtomicWrite(path, data, mode); err != nil {
+		return err
+	}
+	return g.commit(ctx, messageFrom(ctx, "waf-admin: write "+filepath.Base(path)), path)
+}
+
+func (g *Git) List(_ context.Context, dir string) ([]fs.DirEntry, error) { return os.ReadDir(dir) }
+
+func (g *Git) Delete(ctx context.Context, path string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.inside(path); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return g.commit(ctx, messageFrom(ctx, "waf-admin: delete "+filepath.Base(path)), path)
+}
+
+func (g *Git) Rename(ctx context.Context, from, to string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.inside(from, to); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	return g.commit(ctx, messageFrom(ctx, "waf-admin: rename "+filepath.Base(from)+" to "+filepath.Base(to)), from, to)
+}
+
+func (g *Git) MkdirAll(_ context.Context, dir string, mode fs.FileMode) error {
+	return os.MkdirAll(dir, mode)
+}
+
+// inside rejects paths outside the working tree, which could never be
+// committed.
+func (g *Git) inside(paths ...string) error {
+	for _, p := range paths {
+		if _, ok := g.rel(p); !ok {
+			return fmt.Errorf("git storage: %s is outside the working tree %s", p, g.Dir)
+		}
+	}
+	return nil
+}
+
+// commit stages paths and commits them alone, leaving any other pending
+// changes in the tree untouched.
+func (g *Git) commit(ctx context.Context, msg string, paths ...string) error {
+	rels := make([]string, len(paths))
+	for i, p := range paths {
+		rels[i], _ = g.rel(p)
+	}
+	// the change is on disk already; do not let a cancelled request
+	// leave it uncommitted
+	ctx = context.WithoutCancel(ctx)
+	if _, err := g.git(ctx, append([]string{"add", "-A", "--"}, rels...)...); err != nil {
+		log.Error().Err(err).Strs("paths", paths).Msg("git storage: add failed")
+		return &CommitError{err}
+	}
+	if _, err := g.git(ctx, append([]string{"diff", "--cached", "--quiet", "--"}, rels...)...); err == nil {
+		return nil
+	}
+	author := fmt.Sprintf("%s <%s>", g.AuthorName, g.AuthorEmail)
+	if _, err := g.git(ctx, append([]string{"commit", "-q", "--author", author, "-m", msg, "--"}, rels...)...); err != nil {
+		log.Error().Err(err).Strs("paths", paths).Msg("git storage: commit failed")
+		return &CommitError{err}
+	}
+	return nil
+}
+
+func (g *Git) rel(path string) (string, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(g.Dir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+func (g *Git) git(ctx context.Context, args ...string) (string, error) {
+	full := append([]string{"-C", g.Dir, "-c", "user.name=" + g.AuthorName, "-c", "user.email=" + g.AuthorEmail}, args...)
+	out, err := exec.CommandContext(ctx, "git", full...).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
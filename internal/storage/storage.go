@@ -10,5 +10,20 @@ type Storage interface {
 	WriteAtomic(ctx context.Context, path string, data []byte, mode fs.FileMode) error
 	List(ctx context.Context, dir string) ([]fs.DirEntry, error)
 	Delete(ctx context.Context, path string) error
+	Rename(ctx context.Context, from, to string) error
 	MkdirAll(ctx context.Context, dir string, mode fs.FileMode) error
 }
+
+type messageKey struct{}
+
+// WithMessage attaches a commit message for backends that record history.
+func WithMessage(ctx context.Context, msg string) context.Context {
+	return context.WithValue(ctx, messageKey{}, msg)
+}
+
+func messageFrom(ctx context.Context, fallback string) string {
+	if msg, ok := ctx.Value(messageKey{}).(string); ok && msg != "" {
+		return msg
+	}
+	return fallback
+}
@@ -0,0 +1,125 @@
+package wafsim
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+// TestsFile holds a site's regression tests as JSON in <RulesRoot>/<site>/.
+const TestsFile = "tests.json"
+
+const (
+	Allowed = "allowed"
+	Blocked = "blocked"
+)
+
+// Expect is the outcome a test case requires. Outcome and RuleID may be
+// combined; at least one is needed.
+type Expect struct {
+	// Outcome is "allowed" (no interruption) or "blocked".
+	Outcome string `json:"outcome,omitempty"`
+	// RuleID must be among the matched rules.
+	RuleID int `json:"ruleId,omitempty"`
+}
+
+// Case is a stored regression test: a raw request and what the site's
+// rules must do with it.
+type Case struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Request  string    `json:"request"`
+	ClientIP string    `json:"clientIp,omitempty"`
+	Expect   Expect    `json:"expect"`
+	Author   string    `json:"author"`
+	Created  time.Time `json:"created"`
+}
+
+// Validate checks the fields a client supplies.
+func (c *Case) Validate() error {
+	switch {
+	case c.Name == "":
+		return errors.New("name is required")
+	case c.Expect.Outcome != "" && c.Expect.Outcome != Allowed && c.Expect.Outcome != Blocked:
+		return errors.New("expect.outcome must be allowed or blocked")
+	case c.Expect.Outcome == "" && c.Expect.RuleID <= 0:
+		return errors.New("expect needs an outcome or a ruleId")
+	case c.ClientIP != "" && net.ParseIP(c.ClientIP) == nil:
+		return errors.New("invalid clientIp")
+	}
+	if _, err := ParseRequest(c.Request); err != nil {
+		return err
+	}
+	return nil
+}
+
+func NewID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LoadCases parses a tests file; a missing file is an empty suite.
+func LoadCases(b []byte) ([]Case, error) {
+	if len(b) == 0 {
+		return []Case{}, nil
+	}
+	var out []Case
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", TestsFile, err)
+	}
+	return out, nil
+}
+
+func MarshalCases(list []Case) []byte {
+	b, _ := json.MarshalIndent(list, "", "  ")
+	return append(b, '\n')
+}
+
+// CaseResult is the outcome of one test case.
+type CaseResult struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	// Failure says why the case failed.
+	Failure string `json:"failure,omitempty"`
+	Result  Result `json:"result"`
+}
+
+// RunCase evaluates c against w.
+func (w *WAF) RunCase(c Case) CaseResult {
+	cr := CaseResult{ID: c.ID, Name: c.Name}
+	req, err := ParseRequest(c.Request)
+	if err != nil {
+		cr.Failure = err.Error()
+		return cr
+	}
+	ip := c.ClientIP
+	if ip == "" {
+		ip = "127.0.0.1"
+	}
+	if cr.Result, err = w.Run(req, ip); err != nil {
+		cr.Failure = err.Error()
+		return cr
+	}
+	cr.Failure = c.Expect.check(cr.Result)
+	cr.Passed = cr.Failure == ""
+	return cr
+}
+
+func (e Expect) check(res Result) string {
+	switch {
+	case e.Outcome == Allowed && res.Interruption != nil:
+		return fmt.Sprintf("expected allowed, blocked by rule %d", res.Interruption.RuleID)
+	case e.Outcome == Blocked && res.Interruption == nil:
+		return "expected blocked, was allowed"
+	case e.RuleID > 0 && !slices.Contains(res.RuleIDs, e.RuleID):
+		return fmt.Sprintf("expected rule %d to match", e.RuleID)
+	}
+	return ""
+}
@@ -0,0 +1,167 @@
+package wafsim
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/corazawaf/coraza/v3"
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+	"github.com/corazawaf/coraza/v3/types"
+
+	"github.com/Stack-Dash/waf-admin/internal/auditlog"
+)
+
+// Defaults precede every simulated ruleset. Caddy sites set these in their
+// snippet, which is not part of the simulation.
+const Defaults = "SecRuleEngine On\nSecRequestBodyAccess On\n"
+
+// Source is one part of a ruleset, loaded in order: a file on disk, or
+// inline Content such as an unsaved draft. Include and @*FromFile paths in
+// inline content resolve against the working directory.
+type Source struct {
+	Path    string
+	Content string
+}
+
+// WAF is a compiled ruleset.
+type WAF struct{ waf coraza.WAF }
+
+func New(sources []Source) (*WAF, error) {
+	cfg := coraza.NewWAFConfig()
+	for _, src := range sources {
+		if src.Path != "" {
+			cfg = cfg.WithDirectivesFromFile(src.Path)
+		} else {
+			cfg = cfg.WithDirectives(src.Content)
+		}
+	}
+	w, err := coraza.NewWAF(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &WAF{waf: w}, nil
+}
+
+// Interruption is what Caddy would have done instead of proxying.
+type Interruption struct {
+	RuleID int    `json:"ruleId"`
+	Action string `json:"action"`
+	Status int    `json:"status"`
+	Data   string `json:"data,omitempty"`
+}
+
+type Result struct {
+	Interruption *Interruption    `json:"interruption"`
+	AnomalyScore int              `json:"anomalyScore"`
+	RuleIDs      []int            `json:"ruleIds"`
+	Matches      []auditlog.Match `json:"matches"`
+}
+
+// ParseRequest reads a raw HTTP/1.x request. A missing blank line after
+// the headers is added, and a body without Content-Length gets one, so
+// hand-written requests work as expected.
+func ParseRequest(raw string) (*http.Request, error) {
+	raw = strings.TrimLeft(raw, "\r\n")
+	head, body, found := strings.Cut(strings.ReplaceAll(raw, "\r\n", "\n"), "\n\n")
+	if !found {
+		head = strings.TrimRight(head, "\n")
+	}
+	lower := strings.ToLower(head)
+	if body != "" && !strings.Contains(lower, "\ncontent-length:") && !strings.Contains(lower, "\ntransfer-encoding:") {
+		head += "\nContent-Length: " + strconv.Itoa(len(body))
+	}
+	msg := strings.ReplaceAll(head, "\n", "\r\n") + "\r\n\r\n" + body
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(msg)))
+	if err != nil {
+		return nil, fmt.Errorf("parse request: %w", err)
+	}
+	return req, nil
+}
+
+// Run evaluates the request phases of req as coming from clientIP.
+func (w *WAF) Run(req *http.Request, clientIP string) (Result, error) {
+	if net.ParseIP(clientIP) == nil {
+		return Result{}, errors.New("invalid client address")
+	}
+	tx := w.waf.NewTransaction()
+	defer tx.Close()
+
+	tx.ProcessConnection(clientIP, 0, "127.0.0.1", 0)
+	tx.ProcessURI(req.RequestURI, req.Method, req.Proto)
+	if req.Host != "" {
+		tx.AddRequestHeader("Host", req.Host)
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		tx.SetServerName(host)
+	}
+	for k, vs := range req.Header {
+		for _, v := range vs {
+			tx.AddRequestHeader(k, v)
+		}
+	}
+	it := tx.ProcessRequestHeaders()
+	if it == nil && req.Body != nil {
+		var err error
+		if it, _, err = tx.ReadRequestBodyFrom(req.Body); err != nil {
+			return Result{}, err
+		}
+		if it == nil {
+			if it, err = tx.ProcessRequestBody(); err != nil {
+				return Result{}, err
+			}
+		}
+		_, _ = io.Copy(io.Discard, req.Body)
+	}
+
+	res := Result{RuleIDs: []int{}, Matches: []auditlog.Match{}}
+	interruptedBy := 0
+	if it != nil {
+		res.Interruption = &Interruption{RuleID: it.RuleID, Action: it.Action, Status: it.Status, Data: it.Data}
+		interruptedBy = it.RuleID
+	}
+	for _, mr := range tx.MatchedRules() {
+		r := mr.Rule()
+		// CRS setup and flow control rules match on every request
+		// without a message
+		if r.ID() == 0 || (mr.Message() == "" && r.ID() != interruptedBy) {
+			continue
+		}
+		res.RuleIDs = append(res.RuleIDs, r.ID())
+		res.Matches = append(res.Matches, auditlog.Match{
+			RuleID:   r.ID(),
+			Msg:      mr.Message(),
+			Data:     mr.Data(),
+			Severity: severity(r.Severity()),
+			Tags:     r.Tags(),
+			File:     r.File(),
+		})
+	}
+	if st, ok := tx.(plugintypes.TransactionState); ok {
+		// CRS 4 names the total blocking_inbound_anomaly_score, CRS 3
+		// inbound_anomaly_score
+		for _, key := range []string{"blocking_inbound_anomaly_score", "inbound_anomaly_score"} {
+			if v := st.Variables().TX().Get(key); len(v) > 0 {
+				res.AnomalyScore, _ = strconv.Atoi(v[0])
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// severity names s. Coraza reports an unset severity as 0 (emergency),
+// which no CRS rule uses, so that is left out too.
+func severity(s types.RuleSeverity) string {
+	if s <= types.RuleSeverityEmergency || s > types.RuleSeverityDebug {
+		return ""
+	}
+	return s.String()
+}